@@ -0,0 +1,327 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+const lockPollInterval = 100 * time.Millisecond
+
+// Cache is a directory of blobs keyed by digest that can be shared by
+// concurrent hydrate processes. Blobs are stored in blobs/sha256/<hex>, with
+// a lock file per blob in locks/.
+type Cache struct {
+	dir string
+}
+
+func New(dir string) (*Cache, error) {
+	for _, d := range []string{blobsDir(dir), locksDir(dir), tmpDir(dir)} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Fetch writes the blob to dest from the cache if it is there and matches its
+// digest. Otherwise it calls download, which must write the verified blob to
+// dest, and adds dest to the cache. Other processes fetching the same blob
+// wait for download to finish, so that it is only downloaded once.
+func (c *Cache) Fetch(ctx context.Context, blob digest.Digest, dest string, download func() error) (bool, error) {
+	if blob.Algorithm() != digest.SHA256 {
+		return false, download()
+	}
+
+	unlock, err := c.lock(ctx, blob)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	hit, err := c.get(blob, dest)
+	if err != nil || hit {
+		return hit, err
+	}
+
+	if err := download(); err != nil {
+		return false, err
+	}
+
+	return false, c.put(blob, dest)
+}
+
+func (c *Cache) get(blob digest.Digest, dest string) (bool, error) {
+	path := c.blobPath(blob)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if !verify(path, blob) {
+		if err := os.Remove(path); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	/* the modification time records when the blob was last used, for Prune */
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return false, err
+	}
+
+	if err := linkOrCopy(path, dest); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *Cache) put(blob digest.Digest, src string) error {
+	tmp, err := os.CreateTemp(tmpDir(c.dir), blob.Encoded())
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+
+	if err := linkOrCopy(src, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), c.blobPath(blob)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Prune removes blobs that have not been used for longer than maxAge, and
+// then the least recently used blobs until the cache is no bigger than
+// maxSize. Zero means no limit. Blobs that are being fetched are skipped.
+// The lock files of blobs that are no longer cached are removed too.
+func (c *Cache) Prune(maxSize int64, maxAge time.Duration) (int, int64, error) {
+	entries, err := os.ReadDir(blobsDir(c.dir))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	blobs := []os.FileInfo{}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, 0, err
+		}
+		blobs = append(blobs, info)
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].ModTime().Before(blobs[j].ModTime())
+	})
+
+	var total int64
+	for _, b := range blobs {
+		total += b.Size()
+	}
+
+	removed := 0
+	var freed int64
+	for _, b := range blobs {
+		expired := maxAge > 0 && time.Since(b.ModTime()) > maxAge
+		tooBig := maxSize > 0 && total > maxSize
+		if !expired && !tooBig {
+			continue
+		}
+
+		ok, err := c.remove(digest.NewDigestFromEncoded(digest.SHA256, b.Name()))
+		if err != nil {
+			return removed, freed, err
+		}
+		if ok {
+			removed++
+			freed += b.Size()
+			total -= b.Size()
+		}
+	}
+
+	return removed, freed, c.pruneLocks()
+}
+
+// pruneLocks removes the lock files of blobs that are not cached, such as
+// blobs that were pruned or failed to download, unless they are locked
+func (c *Cache) pruneLocks() error {
+	entries, err := os.ReadDir(locksDir(c.dir))
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		hex, ok := strings.CutSuffix(e.Name(), ".lock")
+		if !ok {
+			continue
+		}
+
+		blob := digest.NewDigestFromEncoded(digest.SHA256, hex)
+		unlock, ok, err := c.tryLock(blob)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if _, err := os.Stat(c.blobPath(blob)); !os.IsNotExist(err) {
+			unlock()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := removeLockFile(c.lockPath(blob), unlock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cache) remove(blob digest.Digest) (bool, error) {
+	unlock, ok, err := c.tryLock(blob)
+	if err != nil || !ok {
+		return false, err
+	}
+	defer unlock()
+
+	if err := os.Remove(c.blobPath(blob)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *Cache) lock(ctx context.Context, blob digest.Digest) (func(), error) {
+	for {
+		unlock, ok, err := c.tryLock(blob)
+		if err != nil || ok {
+			return unlock, err
+		}
+
+		select {
+		case <-time.After(lockPollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Cache) tryLock(blob digest.Digest) (func(), bool, error) {
+	path := c.lockPath(blob)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ok, err := lockFile(f)
+	if err != nil || !ok {
+		f.Close()
+		return nil, false, err
+	}
+
+	unlock := func() {
+		unlockFile(f)
+		f.Close()
+	}
+
+	/* Prune may have removed the lock file while this process was opening it */
+	locked, err := f.Stat()
+	if err != nil {
+		unlock()
+		return nil, false, err
+	}
+	current, err := os.Stat(path)
+	if err != nil || !os.SameFile(locked, current) {
+		unlock()
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return nil, false, err
+	}
+
+	return unlock, true, nil
+}
+
+func (c *Cache) blobPath(blob digest.Digest) string {
+	return filepath.Join(blobsDir(c.dir), blob.Encoded())
+}
+
+func (c *Cache) lockPath(blob digest.Digest) string {
+	return filepath.Join(locksDir(c.dir), blob.Encoded()+".lock")
+}
+
+func verify(path string, blob digest.Digest) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)) == blob.Encoded()
+}
+
+// linkOrCopy falls back to copying when src and dest are on different volumes
+func linkOrCopy(src, dest string) error {
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func blobsDir(dir string) string {
+	return filepath.Join(dir, "blobs", "sha256")
+}
+
+func locksDir(dir string) string {
+	return filepath.Join(dir, "locks")
+}
+
+func tmpDir(dir string) string {
+	return filepath.Join(dir, "tmp")
+}
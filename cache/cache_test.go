@@ -0,0 +1,311 @@
+package cache_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/hydrator/cache"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	digest "github.com/opencontainers/go-digest"
+)
+
+var _ = Describe("Cache", func() {
+	var (
+		cacheDir  string
+		outputDir string
+		c         *cache.Cache
+	)
+
+	BeforeEach(func() {
+		var err error
+		cacheDir, err = os.MkdirTemp("", "hydrator.cache")
+		Expect(err).NotTo(HaveOccurred())
+		outputDir, err = os.MkdirTemp("", "hydrator.cache.output")
+		Expect(err).NotTo(HaveOccurred())
+
+		c, err = cache.New(cacheDir)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		Expect(os.RemoveAll(outputDir)).To(Succeed())
+	})
+
+	blobFor := func(data string) digest.Digest {
+		return digest.NewDigestFromEncoded(digest.SHA256, fmt.Sprintf("%x", sha256.Sum256([]byte(data))))
+	}
+
+	writer := func(dest, data string, calls *int32) func() error {
+		return func() error {
+			atomic.AddInt32(calls, 1)
+			return os.WriteFile(dest, []byte(data), 0644)
+		}
+	}
+
+	Describe("Fetch", func() {
+		const data = "some-blob-data"
+		var (
+			blob          digest.Digest
+			downloadCalls int32
+		)
+
+		BeforeEach(func() {
+			blob = blobFor(data)
+			downloadCalls = 0
+		})
+
+		It("downloads a blob that is not cached and then serves it from the cache", func() {
+			dest1 := filepath.Join(outputDir, "first")
+			hit, err := c.Fetch(context.Background(), blob, dest1, writer(dest1, data, &downloadCalls))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hit).To(BeFalse())
+			Expect(filepath.Join(cacheDir, "blobs", "sha256", blob.Encoded())).To(BeAnExistingFile())
+
+			dest2 := filepath.Join(outputDir, "second")
+			hit, err = c.Fetch(context.Background(), blob, dest2, writer(dest2, data, &downloadCalls))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hit).To(BeTrue())
+
+			Expect(downloadCalls).To(Equal(int32(1)))
+			contents, err := os.ReadFile(dest2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal(data))
+		})
+
+		It("replaces an existing file at the destination", func() {
+			dest := filepath.Join(outputDir, "blob")
+			_, err := c.Fetch(context.Background(), blob, dest, writer(dest, data, &downloadCalls))
+			Expect(err).NotTo(HaveOccurred())
+
+			hit, err := c.Fetch(context.Background(), blob, dest, writer(dest, data, &downloadCalls))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hit).To(BeTrue())
+		})
+
+		Context("the cached blob is corrupted", func() {
+			BeforeEach(func() {
+				dest := filepath.Join(outputDir, "first")
+				_, err := c.Fetch(context.Background(), blob, dest, writer(dest, data, &downloadCalls))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.Remove(dest)).To(Succeed())
+
+				Expect(os.WriteFile(filepath.Join(cacheDir, "blobs", "sha256", blob.Encoded()), []byte("corrupted"), 0644)).To(Succeed())
+			})
+
+			It("downloads the blob again", func() {
+				dest := filepath.Join(outputDir, "second")
+				hit, err := c.Fetch(context.Background(), blob, dest, writer(dest, data, &downloadCalls))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(hit).To(BeFalse())
+				Expect(downloadCalls).To(Equal(int32(2)))
+
+				contents, err := os.ReadFile(filepath.Join(cacheDir, "blobs", "sha256", blob.Encoded()))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal(data))
+			})
+		})
+
+		Context("the download fails", func() {
+			It("returns the error and does not cache anything", func() {
+				_, err := c.Fetch(context.Background(), blob, filepath.Join(outputDir, "blob"), func() error {
+					return errors.New("couldn't download")
+				})
+				Expect(err).To(MatchError("couldn't download"))
+				Expect(filepath.Join(cacheDir, "blobs", "sha256", blob.Encoded())).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("the same blob is fetched concurrently", func() {
+			It("downloads it once", func() {
+				var wg sync.WaitGroup
+				for i := 0; i < 3; i++ {
+					dest := filepath.Join(outputDir, fmt.Sprintf("blob%d", i))
+					wg.Add(1)
+					go func() {
+						defer GinkgoRecover()
+						defer wg.Done()
+						_, err := c.Fetch(context.Background(), blob, dest, func() error {
+							time.Sleep(200 * time.Millisecond)
+							return writer(dest, data, &downloadCalls)()
+						})
+						Expect(err).NotTo(HaveOccurred())
+					}()
+				}
+				wg.Wait()
+
+				Expect(downloadCalls).To(Equal(int32(1)))
+			})
+
+			It("stops waiting when the context is done", func() {
+				started := make(chan struct{})
+				release := make(chan struct{})
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					defer close(done)
+					dest := filepath.Join(outputDir, "first")
+					_, err := c.Fetch(context.Background(), blob, dest, func() error {
+						close(started)
+						<-release
+						return writer(dest, data, &downloadCalls)()
+					})
+					Expect(err).NotTo(HaveOccurred())
+				}()
+				<-started
+				defer func() {
+					close(release)
+					<-done
+				}()
+
+				ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+				defer cancel()
+				_, err := c.Fetch(ctx, blob, filepath.Join(outputDir, "second"), func() error { return nil })
+				Expect(err).To(MatchError(context.DeadlineExceeded))
+			})
+		})
+	})
+
+	Describe("Prune", func() {
+		var blobs []digest.Digest
+
+		BeforeEach(func() {
+			blobs = nil
+			for i, data := range []string{"oldest-blob", "older-blob", "newest-blob"} {
+				blob := blobFor(data)
+				dest := filepath.Join(outputDir, blob.Encoded())
+				var calls int32
+				_, err := c.Fetch(context.Background(), blob, dest, writer(dest, data, &calls))
+				Expect(err).NotTo(HaveOccurred())
+
+				lastUsed := time.Now().Add(-time.Duration(3-i) * time.Hour)
+				Expect(os.Chtimes(filepath.Join(cacheDir, "blobs", "sha256", blob.Encoded()), lastUsed, lastUsed)).To(Succeed())
+				blobs = append(blobs, blob)
+			}
+		})
+
+		cached := func() []string {
+			entries, err := os.ReadDir(filepath.Join(cacheDir, "blobs", "sha256"))
+			Expect(err).NotTo(HaveOccurred())
+			names := []string{}
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			return names
+		}
+
+		locks := func() []string {
+			entries, err := os.ReadDir(filepath.Join(cacheDir, "locks"))
+			Expect(err).NotTo(HaveOccurred())
+			names := []string{}
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			return names
+		}
+
+		It("removes blobs that have not been used within the max age", func() {
+			removed, freed, err := c.Prune(0, 150*time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(1))
+			Expect(freed).To(Equal(int64(len("oldest-blob"))))
+			Expect(cached()).To(ConsistOf(blobs[1].Encoded(), blobs[2].Encoded()))
+			Expect(locks()).To(ConsistOf(blobs[1].Encoded()+".lock", blobs[2].Encoded()+".lock"))
+		})
+
+		It("removes the lock files of blobs that are not cached", func() {
+			dest := filepath.Join(outputDir, "failed")
+			_, err := c.Fetch(context.Background(), blobFor("failed-blob"), dest, func() error {
+				return errors.New("oops")
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(locks()).To(ContainElement(blobFor("failed-blob").Encoded() + ".lock"))
+
+			_, _, err = c.Prune(0, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(locks()).To(ConsistOf(blobs[0].Encoded()+".lock", blobs[1].Encoded()+".lock", blobs[2].Encoded()+".lock"))
+		})
+
+		It("removes the least recently used blobs until the cache fits the max size", func() {
+			removed, _, err := c.Prune(int64(len("newest-blob")), 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(Equal(2))
+			Expect(cached()).To(ConsistOf(blobs[2].Encoded()))
+		})
+
+		It("keeps everything without limits", func() {
+			removed, _, err := c.Prune(0, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(BeZero())
+			Expect(cached()).To(HaveLen(3))
+		})
+
+		It("keeps blobs that are being fetched", func() {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				dest := filepath.Join(outputDir, "another")
+				var calls int32
+				_, err := c.Fetch(context.Background(), blobFor("another-blob"), dest, func() error {
+					close(started)
+					<-release
+					return writer(dest, "another-blob", &calls)()
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			<-started
+			defer func() {
+				close(release)
+				<-done
+			}()
+
+			/* a blob left behind by an earlier fetch of the same digest */
+			inUse := filepath.Join(cacheDir, "blobs", "sha256", blobFor("another-blob").Encoded())
+			Expect(os.WriteFile(inUse, []byte("another-blob"), 0644)).To(Succeed())
+			old := time.Now().Add(-24 * time.Hour)
+			Expect(os.Chtimes(inUse, old, old)).To(Succeed())
+
+			_, _, err := c.Prune(0, 150*time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cached()).To(ContainElement(blobFor("another-blob").Encoded()))
+		})
+
+		It("keeps the lock files of blobs that are being fetched", func() {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				dest := filepath.Join(outputDir, "another")
+				var calls int32
+				_, err := c.Fetch(context.Background(), blobFor("another-blob"), dest, func() error {
+					close(started)
+					<-release
+					return writer(dest, "another-blob", &calls)()
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			<-started
+
+			_, _, err := c.Prune(0, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(locks()).To(ContainElement(blobFor("another-blob").Encoded() + ".lock"))
+
+			close(release)
+			<-done
+			Expect(cached()).To(ContainElement(blobFor("another-blob").Encoded()))
+		})
+	})
+})
@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package cache
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile returns false if another process holds the lock
+func lockFile(f *os.File) (bool, error) {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
+
+// removeLockFile removes the lock file before unlocking it, so that a process
+// waiting for the lock finds that the file has gone
+func removeLockFile(path string, unlock func()) error {
+	defer unlock()
+	return os.Remove(path)
+}
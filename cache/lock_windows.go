@@ -0,0 +1,35 @@
+//go:build windows
+// +build windows
+
+package cache
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile returns false if another process holds the lock
+func lockFile(f *os.File) (bool, error) {
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &windows.Overlapped{})
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlockFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &windows.Overlapped{})
+}
+
+// removeLockFile unlocks the lock file first, since an open file cannot be
+// removed. Another process that has opened it since keeps it.
+func removeLockFile(path string, unlock func()) error {
+	unlock()
+	err := os.Remove(path)
+	if errors.Is(err, windows.ERROR_SHARING_VIOLATION) || os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
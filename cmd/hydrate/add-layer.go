@@ -2,6 +2,8 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 
 	"code.cloudfoundry.org/hydrator/layermodifier"
 	directory "code.cloudfoundry.org/hydrator/oci-directory"
@@ -12,6 +14,9 @@ var addLayerCommand = cli.Command{
 	Name:  "add-layer",
 	Usage: "adds a layer to an existing image",
 	Description: `The add-layer command adds a layer to an existing OCI image.
+	The layer descriptor is annotated with hydrator.layerAdded, the name of the layer
+	file in hydrator.layerSource, the time in hydrator.layerCreated, and any -annotation,
+	so that remove-layer can tell which layers hydrator added.
 	Note that the OCI image must exist on disk and that the image will be modified
 	in place`,
 	Flags: []cli.Flag{
@@ -25,6 +30,10 @@ var addLayerCommand = cli.Command{
 			Value: "",
 			Usage: "Path to .tgz file containing the layer to be added to the image",
 		},
+		cli.StringSliceFlag{
+			Name:  "annotation",
+			Usage: "Annotation to record on the layer, as key=value (can be given more than once)",
+		},
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 0, exactArgs); err != nil {
@@ -40,8 +49,28 @@ var addLayerCommand = cli.Command{
 			return errors.New("ERROR: Missing option -ociImage")
 		}
 
+		annotations, err := parseAnnotations(context.StringSlice("annotation"))
+		if err != nil {
+			return err
+		}
+
 		ociDirectory := directory.NewHandler(ociImagePath)
 		layerModifier := layermodifier.New(ociDirectory)
-		return layerModifier.AddLayer(layerPath)
+		return layerModifier.AddLayer(layerPath, annotations)
 	},
 }
+
+func parseAnnotations(values []string) (map[string]string, error) {
+	annotations := map[string]string{}
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("ERROR: Invalid annotation %q, expected key=value", v)
+		}
+		if strings.HasPrefix(key, "hydrator.") {
+			return nil, fmt.Errorf("ERROR: Invalid annotation %q, keys starting with hydrator. are set by hydrate", v)
+		}
+		annotations[key] = value
+	}
+	return annotations, nil
+}
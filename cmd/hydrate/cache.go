@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/hydrator/cache"
+	"github.com/urfave/cli"
+)
+
+var cacheCommand = cli.Command{
+	Name:  "cache",
+	Usage: "manages the layer cache used by download -cacheDir",
+	Subcommands: []cli.Command{
+		cachePruneCommand,
+	},
+}
+
+var cachePruneCommand = cli.Command{
+	Name:  "prune",
+	Usage: "removes layers from the cache",
+	Description: `The prune command removes layers that have not been used for longer than
+	-maxAge, and then the least recently used layers until the cache is no bigger
+	than -maxSize. Layers that are being downloaded are kept. The lock files of
+	layers that are no longer cached are removed too`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "cacheDir",
+			Value:  "",
+			Usage:  "Directory of the layer cache",
+			EnvVar: "HYDRATOR_CACHE_DIR",
+		},
+		cli.StringFlag{
+			Name:  "maxSize",
+			Value: "",
+			Usage: "Maximum size of the cache, e.g. 50GB (default: no limit)",
+		},
+		cli.DurationFlag{
+			Name:  "maxAge",
+			Usage: "Remove layers that have not been used for this long, e.g. 720h (default: no limit)",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 0, exactArgs); err != nil {
+			return err
+		}
+
+		cacheDir := context.String("cacheDir")
+		if cacheDir == "" {
+			return errors.New("ERROR: Missing option -cacheDir")
+		}
+
+		maxSize, err := parseSize(context.String("maxSize"))
+		if err != nil {
+			return err
+		}
+
+		c, err := cache.New(cacheDir)
+		if err != nil {
+			return err
+		}
+
+		removed, freed, err := c.Prune(maxSize, context.Duration("maxAge"))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Removed %d layers, freed %d bytes\n", removed, freed)
+		return nil
+	},
+}
+
+// parseSize accepts a number of bytes with an optional kB, MB, GB or TB suffix
+func parseSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	number := strings.TrimSpace(size)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"TB", 1000 * 1000 * 1000 * 1000},
+		{"GB", 1000 * 1000 * 1000},
+		{"MB", 1000 * 1000},
+		{"KB", 1000},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(strings.ToUpper(number), unit.suffix) {
+			number = strings.TrimSpace(number[:len(number)-len(unit.suffix)])
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("ERROR: Invalid size %q", size)
+	}
+	return int64(n * float64(multiplier)), nil
+}
@@ -2,18 +2,55 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
+	"code.cloudfoundry.org/hydrator/downloader"
 	"code.cloudfoundry.org/hydrator/imagefetcher"
+	"code.cloudfoundry.org/hydrator/progress"
+	"code.cloudfoundry.org/hydrator/registry"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/urfave/cli"
 )
 
 var downloadCommand = cli.Command{
 	Name:  "download",
 	Usage: "downloads an image",
-	Description: `The download command downloads an image from registry.hub.docker.com.
-	The downloaded image is formatted according to the OCI Image Format Specification`,
+	Description: `The download command downloads an image from a registry, which is
+	Docker Hub (index.docker.io) unless the image reference or -registry names another one.
+	Images can be pinned by digest with name@sha256:<digest> or -digest, in which case
+	the downloaded manifest is verified against that digest. The manifest is also
+	verified against the registry's Docker-Content-Digest header, and its digest is
+	recorded in the hydrator.sourceDigest annotation of the written manifest.
+	The downloaded image is formatted according to the OCI Image Format Specification,
+	keeping the environment, entrypoint, labels and history of the image config.
+	With -preserveDigest, the manifest and config are written exactly as the registry
+	sent them, with their docker or OCI media types, so that the manifest in index.json
+	has the same digest as the image in the registry.
+	The image for -os, -arch and -variant (windows/amd64 by default) is picked from a
+	multi-platform image, and the image config must be for the same platform.
+	Credentials are taken from -username/-password if provided, then from
+	-credentialHelper, and otherwise from the auths, credHelpers and credsStore
+	entries of the docker config file.
+	Registries are contacted over HTTPS, except for localhost and any
+	-insecureRegistry, which use plain HTTP.
+	Layers are written to <sha256>.partial until they are verified, and a retried
+	download resumes from where it stopped if the registry supports Range requests.
+	Interrupting the download, or a layer failing after its last retry, removes any
+	partially downloaded layers.
+	With -noTarball, layers already in the output directory are kept if their sha256
+	matches, so running the command again only downloads the missing layers.
+	Layer downloads are retried after network errors, 5xx and 429 responses and
+	sha256 mismatches, with exponential backoff.
+	Each layer is decompressed and checked against the diffID in the image config
+	unless -verifyDiffIDs=false is given.
+	With -chunkSize, large layers are downloaded as parallel Range requests, falling
+	back to a single request if the registry does not support them.
+	With -cacheDir, layers are taken from the cache when possible and added to it
+	after downloading. Use hydrate cache prune to limit its size`,
 	Flags: []cli.Flag{
 		cli.StringFlag{
 			Name:  "outputDir",
@@ -23,30 +60,327 @@ var downloadCommand = cli.Command{
 		cli.StringFlag{
 			Name:  "image",
 			Value: "",
-			Usage: "Name of the image to download",
+			Usage: "Reference of the image to download, e.g. cloudfoundry/windows2016fs or mcr.microsoft.com/windows/nanoserver:ltsc2022",
 		},
 		cli.StringFlag{
 			Name:  "tag",
 			Value: "latest",
-			Usage: "Image tag to download",
+			Usage: "Image tag to download if the image reference does not include one",
+		},
+		cli.StringFlag{
+			Name:  "digest",
+			Value: "",
+			Usage: "Image digest (sha256:...) to download, overrides -tag",
+		},
+		cli.StringFlag{
+			Name:  "registry",
+			Value: "",
+			Usage: "Registry to download from if the image reference does not include one (default: index.docker.io, Docker Hub)",
 		},
 		cli.BoolFlag{
 			Name:  "noTarball",
 			Usage: "Do not output image as a tarball",
 		},
+		cli.BoolFlag{
+			Name:  "preserveDigest",
+			Usage: "Keep the manifest and config exactly as the registry sent them, so the image keeps its digest",
+		},
+		cli.StringFlag{
+			Name:  "os",
+			Value: downloader.DefaultOS,
+			Usage: "Operating system of the image to download",
+		},
+		cli.StringFlag{
+			Name:  "arch",
+			Value: downloader.DefaultArch,
+			Usage: "CPU architecture of the image to download",
+		},
+		cli.StringFlag{
+			Name:  "variant",
+			Value: "",
+			Usage: "CPU variant of the image to download (e.g. v8 for arm64)",
+		},
+		cli.StringFlag{
+			Name:  "osVersion",
+			Value: "",
+			Usage: "Windows build of the host (e.g. 10.0.17763) used to pick an image from a multi-platform image",
+		},
+		cli.StringFlag{
+			Name:   "username",
+			Value:  "",
+			Usage:  "Username for authenticating with the registry",
+			EnvVar: "HYDRATOR_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "password",
+			Value:  "",
+			Usage:  "Password for authenticating with the registry",
+			EnvVar: "HYDRATOR_PASSWORD",
+		},
+		cli.StringFlag{
+			Name:  "credentialHelper",
+			Value: "",
+			Usage: "Name of a docker-credential-<name> helper to get registry credentials from",
+		},
+		cli.StringFlag{
+			Name:  "dockerConfig",
+			Value: "",
+			Usage: "Path to a docker config.json containing registry credentials (default: $DOCKER_CONFIG/config.json or ~/.docker/config.json)",
+		},
+		cli.StringFlag{
+			Name:  "caCert",
+			Value: "",
+			Usage: "Path to a PEM bundle of CA certificates to trust in addition to the system roots",
+		},
+		cli.StringFlag{
+			Name:  "clientCert",
+			Value: "",
+			Usage: "Path to a PEM client certificate for registries that require mutual TLS",
+		},
+		cli.StringFlag{
+			Name:  "clientKey",
+			Value: "",
+			Usage: "Path to the PEM private key of -clientCert",
+		},
+		cli.BoolFlag{
+			Name:  "skipTLSVerify",
+			Usage: "Do not verify the registry certificate",
+		},
+		cli.StringSliceFlag{
+			Name:  "insecureRegistry",
+			Usage: "Registry (host[:port]) to contact over plain HTTP, can be repeated",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "Time allowed for the whole download, e.g. 30m (default: no limit)",
+		},
+		cli.DurationFlag{
+			Name:  "requestTimeout",
+			Usage: "Time allowed for each registry request, including reading a whole layer (default: no limit)",
+		},
+		cli.IntFlag{
+			Name:  "parallelism",
+			Value: downloader.DefaultParallelism,
+			Usage: "Maximum number of layers to download at once",
+		},
+		cli.StringFlag{
+			Name:  "chunkSize",
+			Value: "",
+			Usage: "Download layers bigger than this as parallel byte ranges of this size, e.g. 64MB (default: one request per layer)",
+		},
+		cli.IntFlag{
+			Name:  "chunkParallelism",
+			Value: registry.DefaultChunkParallelism,
+			Usage: "Maximum number of byte ranges of a layer to download at once, see -chunkSize",
+		},
+		cli.IntFlag{
+			Name:  "retryAttempts",
+			Value: downloader.DefaultRetryPolicy().MaxAttempts,
+			Usage: "Maximum number of attempts to download each layer",
+		},
+		cli.DurationFlag{
+			Name:  "retryBackoff",
+			Value: downloader.DefaultRetryPolicy().InitialBackoff,
+			Usage: "Delay before the first retry, doubled for each further retry",
+		},
+		cli.DurationFlag{
+			Name:  "retryMaxBackoff",
+			Value: downloader.DefaultRetryPolicy().MaxBackoff,
+			Usage: "Maximum delay between retries, a layer is not retried if the registry asks to wait longer with Retry-After",
+		},
+		cli.Float64Flag{
+			Name:  "retryJitter",
+			Value: downloader.DefaultRetryPolicy().Jitter,
+			Usage: "Fraction of each retry delay to randomise, between 0 and 1",
+		},
+		cli.BoolTFlag{
+			Name:  "verifyDiffIDs",
+			Usage: "Decompress each layer and check it against the diffID in the image config, disable with -verifyDiffIDs=false",
+		},
+		cli.StringFlag{
+			Name:   "cacheDir",
+			Value:  "",
+			Usage:  "Directory of layers shared between downloads, layers found there are not downloaded again",
+			EnvVar: "HYDRATOR_CACHE_DIR",
+		},
+		cli.StringFlag{
+			Name:  "progress",
+			Value: "auto",
+			Usage: "Layer download progress: tty for progress bars, json for newline delimited JSON events on stdout (logs go to stderr), plain for log lines only, or auto for tty on a terminal and plain otherwise",
+		},
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 0, exactArgs); err != nil {
 			return err
 		}
 
-		logger := log.New(os.Stdout, "", 0)
+		logger, reporter, err := progressOutput(context.String("progress"))
+		if err != nil {
+			return err
+		}
 
 		imageName := context.String("image")
 		if imageName == "" {
 			return errors.New("ERROR: No image name provided")
 		}
 
-		return imagefetcher.New(logger, context.String("outputDir"), imageName, context.String("tag"), "", context.Bool("noTarball")).Run()
+		if digest := context.String("digest"); digest != "" {
+			if strings.Contains(imageName, "@") {
+				return errors.New("ERROR: Image reference already contains a digest")
+			}
+			imageName = imageName + "@" + digest
+		}
+
+		if err := validateOSVersion(context.String("osVersion")); err != nil {
+			return err
+		}
+
+		ref, err := imagefetcher.ParseReference(imageName, context.String("tag"), context.String("registry"))
+		if err != nil {
+			return fmt.Errorf("ERROR: %s", err.Error())
+		}
+
+		credentials, err := credentialProvider(context, ref.Context().RegistryStr())
+		if err != nil {
+			return err
+		}
+
+		chunkSize, err := parseSize(context.String("chunkSize"))
+		if err != nil {
+			return err
+		}
+
+		retry := &downloader.RetryPolicy{
+			MaxAttempts:    context.Int("retryAttempts"),
+			InitialBackoff: context.Duration("retryBackoff"),
+			MaxBackoff:     context.Duration("retryMaxBackoff"),
+			Jitter:         context.Float64("retryJitter"),
+		}
+		if err := validateRetryPolicy(retry); err != nil {
+			return err
+		}
+
+		ctx, cancel := commandContext(context.Duration("timeout"))
+		defer cancel()
+
+		return imagefetcher.New(logger, context.String("outputDir"), imageName, context.String("tag"), imagefetcher.Options{
+			Registry:    context.String("registry"),
+			NoTarball:   context.Bool("noTarball"),
+			Credentials: credentials,
+			Platform: v1.Platform{
+				OS:           context.String("os"),
+				Architecture: context.String("arch"),
+				Variant:      context.String("variant"),
+				OSVersion:    context.String("osVersion"),
+			},
+			TLS: registry.TLSOptions{
+				CACertFile:         context.String("caCert"),
+				ClientCertFile:     context.String("clientCert"),
+				ClientKeyFile:      context.String("clientKey"),
+				InsecureSkipVerify: context.Bool("skipTLSVerify"),
+			},
+			InsecureRegistries: context.StringSlice("insecureRegistry"),
+			RequestTimeout:     context.Duration("requestTimeout"),
+			Parallelism:        context.Int("parallelism"),
+			ChunkSize:          chunkSize,
+			ChunkParallelism:   context.Int("chunkParallelism"),
+			Retry:              retry,
+			Progress:           reporter,
+			CacheDir:           context.String("cacheDir"),
+			VerifyDiffIDs:      context.BoolT("verifyDiffIDs"),
+			PreserveDigest:     context.Bool("preserveDigest"),
+		}).Run(ctx)
 	},
 }
+
+// validateOSVersion accepts the forms a multi-platform image is matched with:
+// a build, such as 17763, or a full version, such as 10.0.17763 or 10.0.17763.1577
+func validateOSVersion(osVersion string) error {
+	if osVersion == "" {
+		return nil
+	}
+
+	parts := strings.Split(osVersion, ".")
+	valid := len(parts) == 1 || len(parts) == 3 || len(parts) == 4
+	for _, p := range parts {
+		if n, err := strconv.Atoi(p); err != nil || n < 0 {
+			valid = false
+		}
+	}
+
+	if !valid {
+		return fmt.Errorf("ERROR: Invalid -osVersion %q, expected a Windows build such as 17763, 10.0.17763 or 10.0.17763.1577", osVersion)
+	}
+	return nil
+}
+
+func validateRetryPolicy(p *downloader.RetryPolicy) error {
+	switch {
+	case p.MaxAttempts < 1:
+		return fmt.Errorf("ERROR: Invalid -retryAttempts %d, must be at least 1", p.MaxAttempts)
+	case p.InitialBackoff < 0:
+		return fmt.Errorf("ERROR: Invalid -retryBackoff %s, must not be negative", p.InitialBackoff)
+	case p.MaxBackoff < p.InitialBackoff:
+		return fmt.Errorf("ERROR: Invalid -retryMaxBackoff %s, must be at least -retryBackoff %s", p.MaxBackoff, p.InitialBackoff)
+	case p.Jitter < 0 || p.Jitter > 1:
+		return fmt.Errorf("ERROR: Invalid -retryJitter %g, must be between 0 and 1", p.Jitter)
+	}
+	return nil
+}
+
+func progressOutput(mode string) (*log.Logger, progress.Reporter, error) {
+	if mode == "auto" {
+		mode = "plain"
+		if progress.IsTerminal(os.Stdout) {
+			mode = "tty"
+		}
+	}
+
+	switch mode {
+	case "plain":
+		return log.New(os.Stdout, "", 0), nil, nil
+	case "tty":
+		bars := progress.NewBars(os.Stdout)
+		return log.New(bars, "", 0), bars, nil
+	case "json":
+		return log.New(os.Stderr, "", 0), progress.NewJSON(os.Stdout), nil
+	default:
+		return nil, nil, fmt.Errorf("ERROR: Invalid -progress %q, must be one of auto, tty, json or plain", mode)
+	}
+}
+
+// credentialProvider only gives -username and -password to the image's
+// registry, so that they are not sent to other hosts that a manifest names
+func credentialProvider(context *cli.Context, registryHost string) (registry.CredentialProvider, error) {
+	username := context.String("username")
+	if username != "" {
+		password := context.String("password")
+		if password == "" {
+			return nil, errors.New("ERROR: Missing option -password")
+		}
+		return registry.StaticCredentials(registryHost, registry.Credentials{Username: username, Password: password}), nil
+	}
+
+	if helper := context.String("credentialHelper"); helper != "" {
+		return registry.NewCredentialHelper(helper), nil
+	}
+
+	path := context.String("dockerConfig")
+	if path != "" {
+		return registry.LoadDockerConfig(path)
+	}
+
+	path = registry.DefaultDockerConfigPath()
+	if path == "" {
+		return nil, nil
+	}
+
+	dockerConfig, err := registry.LoadDockerConfig(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return dockerConfig, nil
+}
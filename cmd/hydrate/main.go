@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/urfave/cli"
 )
@@ -21,6 +25,7 @@ func main() {
 		downloadCommand,
 		addLayerCommand,
 		removeLayerCommand,
+		cacheCommand,
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -54,6 +59,21 @@ func checkArgs(context *cli.Context, expected, checkType int) error {
 	return nil
 }
 
+// commandContext is cancelled on SIGINT or SIGTERM, or once timeout has
+// passed if it is not zero
+func commandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if timeout == 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
 func fatal(err error) {
 	fmt.Fprintln(os.Stderr, err)
 	os.Exit(1)
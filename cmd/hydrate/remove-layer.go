@@ -2,17 +2,26 @@ package main
 
 import (
 	"errors"
+	"fmt"
 
 	"code.cloudfoundry.org/hydrator/layermodifier"
 	directory "code.cloudfoundry.org/hydrator/oci-directory"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/urfave/cli"
 )
 
 var removeLayerCommand = cli.Command{
 	Name:  "remove-layer",
-	Usage: "removes the top layer from an existing image if it was added by hydrator",
-	Description: `The remove-layer command removes the top layer from an existing OCI image
-	if that layer was added by hydrator.
+	Usage: "removes layers added by hydrator from an existing image",
+	Description: `The remove-layer command removes the top -count layers (1 by default) from
+	an existing OCI image, or with -all every layer at the top that was added by hydrator.
+	With -digest or -index it removes a single layer from anywhere in the image instead,
+	where -index counts from 0 at the bottom layer.
+	It refuses to remove a layer that was not added by hydrator, unless -force is given
+	with -digest or -index, and then leaves the image unchanged and exits with an error.
+	Without any of these options, the top layer is removed if hydrator added it, and
+	otherwise the image is left unchanged and the command succeeds. A removed layer's blob
+	is kept while another manifest in the image still refers to it.
 	Note that the OCI image must exist on disk and that the image will be modified
 	in place`,
 	Flags: []cli.Flag{
@@ -21,6 +30,28 @@ var removeLayerCommand = cli.Command{
 			Value: "",
 			Usage: "Path to the image from which the layer will be removed",
 		},
+		cli.IntFlag{
+			Name:  "count",
+			Value: 1,
+			Usage: "Number of layers to remove from the top of the image",
+		},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "Remove all of the layers at the top of the image that were added by hydrator",
+		},
+		cli.StringFlag{
+			Name:  "digest",
+			Value: "",
+			Usage: "Digest of a single layer to remove",
+		},
+		cli.IntFlag{
+			Name:  "index",
+			Usage: "Index of a single layer to remove, counting from 0 at the bottom of the image",
+		},
+		cli.BoolFlag{
+			Name:  "force",
+			Usage: "Remove the -digest or -index layer even if it was not added by hydrator",
+		},
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 0, exactArgs); err != nil {
@@ -32,8 +63,44 @@ var removeLayerCommand = cli.Command{
 			return errors.New("ERROR: Missing option -ociImage")
 		}
 
+		layerDigest := context.String("digest")
+		byIndex := context.IsSet("index")
+		if layerDigest != "" || byIndex {
+			if layerDigest != "" && byIndex {
+				return errors.New("ERROR: -digest and -index cannot be used together")
+			}
+			if context.IsSet("count") || context.Bool("all") {
+				return errors.New("ERROR: -count and -all cannot be used with -digest or -index")
+			}
+		} else if context.Bool("force") {
+			return errors.New("ERROR: -force can only be used with -digest or -index")
+		}
+
 		ociDirectory := directory.NewHandler(ociImagePath)
 		layerModifier := layermodifier.New(ociDirectory)
-		return layerModifier.RemoveHydratorLayer()
+
+		if layerDigest != "" {
+			d, err := digest.Parse(layerDigest)
+			if err != nil {
+				return fmt.Errorf("ERROR: Invalid digest %q: %s", layerDigest, err)
+			}
+			return layerModifier.RemoveLayerByDigest(d, context.Bool("force"))
+		}
+		if byIndex {
+			return layerModifier.RemoveLayer(context.Int("index"), context.Bool("force"))
+		}
+
+		if !context.IsSet("count") && !context.Bool("all") {
+			return layerModifier.RemoveHydratorLayer()
+		}
+
+		count := context.Int("count")
+		if context.Bool("all") {
+			count = 0
+		} else if count < 1 {
+			return errors.New("ERROR: -count must be at least 1")
+		}
+
+		return layerModifier.RemoveHydratorLayers(count)
 	},
 }
@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// verifyDiffID decompresses a downloaded layer and checks it against the
+// diffID from the image config. The layer has already been checked against
+// its own digest, so an uncompressed layer only needs the digests compared.
+// It stops once ctx is done, since a large layer takes a while to decompress.
+func (d *Downloader) verifyDiffID(ctx context.Context, l v1.Descriptor, diffId digest.Digest) error {
+	if ociLayerMediaType(l.MediaType) == v1.MediaTypeImageLayer {
+		if l.Digest != diffId {
+			return &DiffIDMismatchError{DiffID: diffId.Encoded(), SHA: l.Digest.Encoded(), Actual: l.Digest.String()}
+		}
+		return nil
+	}
+
+	if !diffId.Algorithm().Available() {
+		return &LayerDownloadError{DiffID: diffId.Encoded(), SHA: l.Digest.Encoded(), Cause: digest.ErrDigestUnsupported}
+	}
+
+	f, err := os.Open(filepath.Join(d.downloadDir, l.Digest.Encoded()))
+	if err != nil {
+		return &LayerDownloadError{DiffID: diffId.Encoded(), SHA: l.Digest.Encoded(), Cause: err}
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(&contextReader{ctx: ctx, reader: f})
+	if err != nil {
+		return &LayerDownloadError{DiffID: diffId.Encoded(), SHA: l.Digest.Encoded(), Cause: err}
+	}
+	defer gz.Close()
+
+	digester := diffId.Algorithm().Digester()
+	if _, err := io.Copy(digester.Hash(), gz); err != nil {
+		return &LayerDownloadError{DiffID: diffId.Encoded(), SHA: l.Digest.Encoded(), Cause: err}
+	}
+
+	if actual := digester.Digest(); actual != diffId {
+		return &DiffIDMismatchError{DiffID: diffId.Encoded(), SHA: l.Digest.Encoded(), Actual: actual.String()}
+	}
+	return nil
+}
+
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
@@ -1,117 +1,279 @@
 package downloader
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
 	"time"
 
+	"code.cloudfoundry.org/hydrator/progress"
 	digest "github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+const ociNonDistributableLayer = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+
 //go:generate counterfeiter -o fakes/registry.go --fake-name Registry . Registry
 type Registry interface {
-	Manifest() (v1.Manifest, error)
-	Config(v1.Descriptor) (v1.Image, error)
-	DownloadLayer(v1.Descriptor, string) error
+	Manifest(context.Context) (v1.Manifest, digest.Digest, []byte, error)
+	Config(context.Context, v1.Descriptor) (v1.Image, []byte, error)
+	DownloadLayer(context.Context, v1.Descriptor, string) error
+	RemovePartial(v1.Descriptor, string) error
 }
 
+// DefaultParallelism matches the number of concurrent downloads docker uses
+const DefaultParallelism = 3
+
+const (
+	DefaultOS   = "windows"
+	DefaultArch = "amd64"
+)
+
 type Downloader struct {
-	downloadDir string
-	registry    Registry
-	logger      *log.Logger
+	downloadDir   string
+	registry      Registry
+	logger        *log.Logger
+	parallelism   int
+	retry         RetryPolicy
+	progress      progress.Reporter
+	verifyDiffIDs bool
+	platform      v1.Platform
 }
 
-func New(logger *log.Logger, downloadDir string, registry Registry) *Downloader {
+type Options struct {
+	// Parallelism is the most layers downloaded at once, including retries.
+	// Zero means DefaultParallelism.
+	Parallelism int
+	// Retry defaults to DefaultRetryPolicy
+	Retry *RetryPolicy
+	// Progress is told when each layer download starts and finishes
+	Progress progress.Reporter
+	// VerifyDiffIDs decompresses each layer once it is downloaded and checks
+	// it against the diffID in the image config
+	VerifyDiffIDs bool
+	// Platform is checked against the image config. An empty OS or
+	// Architecture means DefaultOS or DefaultArch.
+	Platform v1.Platform
+}
+
+func New(logger *log.Logger, downloadDir string, registry Registry, opts Options) *Downloader {
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+
+	retry := DefaultRetryPolicy()
+	if opts.Retry != nil {
+		retry = *opts.Retry
+	}
+
+	platform := opts.Platform
+	if platform.OS == "" {
+		platform.OS = DefaultOS
+	}
+	if platform.Architecture == "" {
+		platform.Architecture = DefaultArch
+	}
+
 	d := &Downloader{
-		downloadDir: downloadDir,
-		registry:    registry,
-		logger:      logger,
+		downloadDir:   downloadDir,
+		registry:      registry,
+		logger:        logger,
+		parallelism:   parallelism,
+		retry:         retry,
+		progress:      opts.Progress,
+		verifyDiffIDs: opts.VerifyDiffIDs,
+		platform:      platform,
 	}
 	return d
 }
 
-func (d *Downloader) Run() ([]v1.Descriptor, []digest.Digest, error) {
-	registryManifest, err := d.registry.Manifest()
+// Image describes the image that Run downloaded
+type Image struct {
+	Layers  []v1.Descriptor
+	DiffIDs []digest.Digest
+	// ManifestDigest is the digest of the image manifest in the registry
+	ManifestDigest digest.Digest
+	// Config is the image config from the registry
+	Config v1.Image
+	// RawManifest and RawConfig are the manifest and config exactly as the
+	// registry sent them
+	RawManifest []byte
+	RawConfig   []byte
+}
+
+// Run stops retrying and returns once ctx is done or a layer fails, after
+// waiting for the layer downloads in flight to finish cleaning up
+func (d *Downloader) Run(ctx context.Context) (Image, error) {
+	registryManifest, manifestDigest, rawManifest, err := d.registry.Manifest(ctx)
 	if err != nil {
-		return nil, nil, err
+		return Image{}, err
 	}
 
-	registryConfig, err := d.registry.Config(registryManifest.Config)
+	registryConfig, rawConfig, err := d.registry.Config(ctx, registryManifest.Config)
 	if err != nil {
-		return nil, nil, err
+		return Image{}, err
 	}
 
-	if registryConfig.OS != "windows" {
-		return nil, nil, fmt.Errorf("invalid container OS: %s", registryConfig.OS)
+	if registryConfig.OS != d.platform.OS {
+		return Image{}, fmt.Errorf("invalid container OS: %s", registryConfig.OS)
+	}
+	if registryConfig.Architecture != d.platform.Architecture {
+		return Image{}, fmt.Errorf("invalid container arch: %s", registryConfig.Architecture)
 	}
-	if registryConfig.Architecture != "amd64" {
-		return nil, nil, fmt.Errorf("invalid container arch: %s", registryConfig.Architecture)
+	/* configs often leave out the variant even when the index has one */
+	if d.platform.Variant != "" && registryConfig.Variant != "" && registryConfig.Variant != d.platform.Variant {
+		return Image{}, fmt.Errorf("invalid container variant: %s", registryConfig.Variant)
 	}
 
 	totalLayers := len(registryManifest.Layers)
 	diffIds := registryConfig.RootFS.DiffIDs
 
 	if totalLayers != len(diffIds) {
-		return nil, nil, fmt.Errorf("mismatch: %d layers, %d diffIds", totalLayers, len(diffIds))
+		return Image{}, fmt.Errorf("mismatch: %d layers, %d diffIds", totalLayers, len(diffIds))
 	}
 
 	d.logger.Printf("Downloading %d layers...\n", totalLayers)
-	wg := sync.WaitGroup{}
-	errChan := make(chan error, 1)
 
 	downloadedLayers := []v1.Descriptor{}
-
-	for i, layer := range registryManifest.Layers {
-		l := layer
-		diffId := diffIds[i]
-
-		ociLayer := v1.Descriptor{
-			MediaType: v1.MediaTypeImageLayerGzip,
+	for _, l := range registryManifest.Layers {
+		downloadedLayers = append(downloadedLayers, v1.Descriptor{
+			MediaType: ociLayerMediaType(l.MediaType),
 			Size:      l.Size,
 			Digest:    l.Digest,
-		}
+		})
+	}
+
+	/* the first layer that fails cancels the others, which remove their partial blobs */
+	downloadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		downloadedLayers = append(downloadedLayers, ociLayer)
+	layerErrs := make([]error, totalLayers)
+
+	/* each worker retries its layer before taking the next one, so retries count towards the limit */
+	layerIndexes := make(chan int)
+	go func() {
+		defer close(layerIndexes)
+		for i := range registryManifest.Layers {
+			select {
+			case layerIndexes <- i:
+			case <-downloadCtx.Done():
+				return
+			}
+		}
+	}()
 
+	wg := sync.WaitGroup{}
+	for w := 0; w < d.parallelism && w < totalLayers; w++ {
 		wg.Add(1)
 		go func() {
-			d.logger.Printf("Layer diffID: %.8s, sha256: %.8s begin\n", diffId.Encoded(), l.Digest.Encoded())
 			defer wg.Done()
-			attempt := 0
-			for {
-				attempt += 1
-				err := d.registry.DownloadLayer(l, d.downloadDir)
-				if err != nil {
-					d.logger.Printf("Attempt %d failed downloading layer with diffID: %.8s, sha256: %.8s: %s\n", attempt, diffId.Encoded(), l.Digest.Encoded(), err)
-
-					if attempt >= 5 {
-						errChan <- &MaxLayerDownloadRetriesError{DiffID: diffId.Encoded(), SHA: l.Digest.Encoded()}
-						break
-					}
-
-					time.Sleep(time.Duration(attempt) * time.Second)
+			for i := range layerIndexes {
+				if downloadCtx.Err() != nil {
 					continue
 				}
-
-				d.logger.Printf("Layer diffID: %.8s, sha256: %.8s end\n", diffId.Encoded(), l.Digest.Encoded())
-				break
+				if err := d.downloadLayer(downloadCtx, registryManifest.Layers[i], diffIds[i]); err != nil {
+					layerErrs[i] = err
+					cancel()
+				}
 			}
 		}()
 	}
+	wg.Wait()
 
-	wgEmpty := make(chan interface{}, 1)
-	go func() {
-		wg.Wait()
-		wgEmpty <- nil
-	}()
+	if err := ctx.Err(); err != nil {
+		return Image{}, err
+	}
+
+	if err := newLayerDownloadErrors(layerErrs); err != nil {
+		return Image{}, err
+	}
+
+	return Image{
+		Layers:         downloadedLayers,
+		DiffIDs:        diffIds,
+		ManifestDigest: manifestDigest,
+		Config:         registryConfig,
+		RawManifest:    rawManifest,
+		RawConfig:      rawConfig,
+	}, nil
+}
+
+// downloadLayer returns nil if ctx is done before the layer is downloaded, Run reports the context error
+func (d *Downloader) downloadLayer(ctx context.Context, l v1.Descriptor, diffId digest.Digest) error {
+	if d.progress != nil {
+		d.progress.Start(l.Digest, l.Size)
+	}
+
+	err := d.downloadLayerWithRetries(ctx, l, diffId)
+	if err != nil {
+		/* the partial layer is only kept for the retries of this run */
+		if removeErr := d.registry.RemovePartial(l, d.downloadDir); removeErr != nil {
+			d.logger.Printf("Failed to remove partial layer with sha256: %.8s: %s\n", l.Digest.Encoded(), removeErr)
+		}
+	}
+	if err == nil && d.verifyDiffIDs {
+		err = d.verifyDiffID(ctx, l, diffId)
+	}
 
-	select {
-	case <-wgEmpty:
-	case downloadErr := <-errChan:
-		return nil, nil, downloadErr
+	if d.progress != nil {
+		d.progress.Finish(l.Digest, err)
 	}
 
-	return downloadedLayers, diffIds, nil
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return nil
+	}
+	return err
+}
+
+func (d *Downloader) downloadLayerWithRetries(ctx context.Context, l v1.Descriptor, diffId digest.Digest) error {
+	d.logger.Printf("Layer diffID: %.8s, sha256: %.8s begin\n", diffId.Encoded(), l.Digest.Encoded())
+	attempt := 0
+	for {
+		attempt += 1
+		err := d.registry.DownloadLayer(ctx, l, d.downloadDir)
+		if err == nil {
+			break
+		}
+		/* failures caused by the cancellation, or that would have been retried, are not worth reporting */
+		if ctx.Err() != nil && (errors.Is(err, ctx.Err()) || d.retry.retryable(err)) {
+			return ctx.Err()
+		}
+
+		d.logger.Printf("Attempt %d failed downloading layer with diffID: %.8s, sha256: %.8s: %s\n", attempt, diffId.Encoded(), l.Digest.Encoded(), err)
+
+		if !d.retry.retryable(err) {
+			return &LayerDownloadError{DiffID: diffId.Encoded(), SHA: l.Digest.Encoded(), Cause: err}
+		}
+
+		if attempt >= d.retry.MaxAttempts {
+			return &MaxLayerDownloadRetriesError{DiffID: diffId.Encoded(), SHA: l.Digest.Encoded(), Cause: err}
+		}
+
+		if d.retry.waitsTooLong(err) {
+			return &RetryAfterTooLongError{DiffID: diffId.Encoded(), SHA: l.Digest.Encoded(), RetryAfter: retryAfter(err), MaxBackoff: d.retry.MaxBackoff, Cause: err}
+		}
+
+		select {
+		case <-time.After(d.retry.Backoff(attempt+1, err)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	d.logger.Printf("Layer diffID: %.8s, sha256: %.8s end\n", diffId.Encoded(), l.Digest.Encoded())
+	return nil
+}
+
+// ociLayerMediaType maps docker and non-distributable layers to the OCI layer
+// type with the same compression, since the blob is stored in the image
+func ociLayerMediaType(mediaType string) string {
+	switch mediaType {
+	case v1.MediaTypeImageLayer, ociNonDistributableLayer:
+		return v1.MediaTypeImageLayer
+	default:
+		return v1.MediaTypeImageLayerGzip
+	}
 }
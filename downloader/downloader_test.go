@@ -2,12 +2,21 @@ package downloader_test
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"code.cloudfoundry.org/hydrator/downloader"
 	"code.cloudfoundry.org/hydrator/downloader/fakes"
+	progressfakes "code.cloudfoundry.org/hydrator/progress/fakes"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -26,9 +35,13 @@ var _ = Describe("Downloader", func() {
 		sourceConfig   v1.Image
 		manifestConfig v1.Descriptor
 		manifest       v1.Manifest
+		manifestDigest digest.Digest
+		rawManifest    []byte
+		rawConfig      []byte
 		registry       *fakes.Registry
 		d              *downloader.Downloader
 		logBuffer      *bytes.Buffer
+		retryPolicy    downloader.RetryPolicy
 	)
 
 	BeforeEach(func() {
@@ -38,6 +51,9 @@ var _ = Describe("Downloader", func() {
 		}
 		manifestConfig = v1.Descriptor{Digest: "config", Size: 7777}
 		manifest = v1.Manifest{Layers: sourceLayers, Config: manifestConfig}
+		manifestDigest = digest.FromString("some-manifest")
+		rawManifest = []byte("some-manifest")
+		rawConfig = []byte("some-config")
 
 		sourceDiffIds = []digest.Digest{
 			digest.NewDigestFromEncoded(digest.SHA256, "aaaaaa"),
@@ -53,62 +69,343 @@ var _ = Describe("Downloader", func() {
 
 		registry = &fakes.Registry{}
 
-		registry.ManifestReturnsOnCall(0, manifest, nil)
-		registry.ConfigReturnsOnCall(0, sourceConfig, nil)
+		registry.ManifestReturnsOnCall(0, manifest, manifestDigest, rawManifest, nil)
+		registry.ConfigReturnsOnCall(0, sourceConfig, rawConfig, nil)
 
 		logBuffer = new(bytes.Buffer)
-		d = downloader.New(log.New(io.MultiWriter(GinkgoWriter, logBuffer), "", 0), downloadDir, registry)
+		retryPolicy = downloader.DefaultRetryPolicy()
+		retryPolicy.InitialBackoff = 10 * time.Millisecond
+		d = downloader.New(log.New(io.MultiWriter(GinkgoWriter, logBuffer), "", 0), downloadDir, registry, downloader.Options{Retry: &retryPolicy})
 	})
 
 	Describe("Run", func() {
-		It("Uses the manifest to download the config, all the layers and returns the proper descriptors + diffIds", func() {
-			layers, diffIds, err := d.Run()
+		It("Uses the manifest to download the config, all the layers and returns the proper descriptors, diffIds and manifest digest", func() {
+			image, err := d.Run(context.Background())
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(layers[0].Digest).To(Equal(digest.Digest("sha256:layer1")))
-			Expect(layers[0].Size).To(Equal(int64(1234)))
-			Expect(layers[0].MediaType).To(Equal(v1.MediaTypeImageLayerGzip))
+			Expect(image.Layers[0].Digest).To(Equal(digest.Digest("sha256:layer1")))
+			Expect(image.Layers[0].Size).To(Equal(int64(1234)))
+			Expect(image.Layers[0].MediaType).To(Equal(v1.MediaTypeImageLayerGzip))
 
-			Expect(layers[1].Digest).To(Equal(digest.Digest("sha256:layer2")))
-			Expect(layers[1].Size).To(Equal(int64(6789)))
-			Expect(layers[1].MediaType).To(Equal(v1.MediaTypeImageLayerGzip))
+			Expect(image.Layers[1].Digest).To(Equal(digest.Digest("sha256:layer2")))
+			Expect(image.Layers[1].Size).To(Equal(int64(6789)))
+			Expect(image.Layers[1].MediaType).To(Equal(v1.MediaTypeImageLayerGzip))
 
-			Expect(diffIds).To(ConsistOf(sourceDiffIds))
+			Expect(image.DiffIDs).To(ConsistOf(sourceDiffIds))
+			Expect(image.ManifestDigest).To(Equal(manifestDigest))
+			Expect(image.Config).To(Equal(sourceConfig))
+			Expect(image.RawManifest).To(Equal(rawManifest))
+			Expect(image.RawConfig).To(Equal(rawConfig))
 
 			Expect(registry.ManifestCallCount()).To(Equal(1))
 			Expect(registry.ConfigCallCount()).To(Equal(1))
-			Expect(registry.ConfigArgsForCall(0)).To(Equal(manifestConfig))
+			_, config := registry.ConfigArgsForCall(0)
+			Expect(config).To(Equal(manifestConfig))
 
 			Expect(registry.DownloadLayerCallCount()).To(Equal(2))
-			l1, dir := registry.DownloadLayerArgsForCall(0)
+			_, l1, dir := registry.DownloadLayerArgsForCall(0)
 			Expect(dir).To(Equal("some-directory"))
 
-			l2, dir := registry.DownloadLayerArgsForCall(1)
+			_, l2, dir := registry.DownloadLayerArgsForCall(1)
 			Expect(dir).To(Equal("some-directory"))
 
 			Expect([]v1.Descriptor{l1, l2}).To(ConsistOf(sourceLayers))
 		})
 
+		Context("progress is being reported", func() {
+			var reporter *progressfakes.Reporter
+
+			BeforeEach(func() {
+				reporter = &progressfakes.Reporter{}
+				d = downloader.New(log.New(io.MultiWriter(GinkgoWriter, logBuffer), "", 0), downloadDir, registry, downloader.Options{Retry: &retryPolicy, Progress: reporter})
+			})
+
+			It("reports when each layer starts and finishes", func() {
+				_, err := d.Run(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(reporter.StartCallCount()).To(Equal(2))
+				started := map[digest.Digest]int64{}
+				for i := 0; i < 2; i++ {
+					blob, total := reporter.StartArgsForCall(i)
+					started[blob] = total
+				}
+				Expect(started).To(Equal(map[digest.Digest]int64{
+					sourceLayers[0].Digest: 1234,
+					sourceLayers[1].Digest: 6789,
+				}))
+
+				Expect(reporter.FinishCallCount()).To(Equal(2))
+				for i := 0; i < 2; i++ {
+					_, err := reporter.FinishArgsForCall(i)
+					Expect(err).NotTo(HaveOccurred())
+				}
+			})
+
+			Context("a layer fails", func() {
+				BeforeEach(func() {
+					registry.DownloadLayerReturns(errors.New("not found"))
+				})
+
+				It("reports the error", func() {
+					_, err := d.Run(context.Background())
+					Expect(err).To(HaveOccurred())
+
+					Expect(reporter.FinishCallCount()).To(BeNumerically(">", 0))
+					_, finishErr := reporter.FinishArgsForCall(0)
+					Expect(finishErr).To(BeAssignableToTypeOf(&downloader.LayerDownloadError{}))
+				})
+			})
+		})
+
+		Context("the manifest has uncompressed OCI layers", func() {
+			BeforeEach(func() {
+				sourceLayers[0].MediaType = "application/vnd.oci.image.layer.v1.tar"
+				sourceLayers[1].MediaType = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+				manifest = v1.Manifest{Layers: sourceLayers, Config: manifestConfig}
+				registry.ManifestReturnsOnCall(0, manifest, manifestDigest, rawManifest, nil)
+			})
+
+			It("keeps the compression of each layer in the media type", func() {
+				image, err := d.Run(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(image.Layers[0].MediaType).To(Equal(v1.MediaTypeImageLayer))
+				Expect(image.Layers[1].MediaType).To(Equal(v1.MediaTypeImageLayerGzip))
+			})
+		})
+
+		Context("diffIDs are verified", func() {
+			var (
+				layerDir   string
+				layerFiles map[digest.Digest][]byte
+			)
+
+			BeforeEach(func() {
+				var err error
+				layerDir, err = os.MkdirTemp("", "hydrate.downloader.test")
+				Expect(err).NotTo(HaveOccurred())
+
+				gzipped, diffId := gzipLayer("some-tar-data")
+				uncompressed := []byte("more-tar-data")
+				uncompressedDigest := digest.FromBytes(uncompressed)
+
+				sourceLayers = []v1.Descriptor{
+					{Digest: digest.FromBytes(gzipped), Size: int64(len(gzipped)), MediaType: v1.MediaTypeImageLayerGzip},
+					{Digest: uncompressedDigest, Size: int64(len(uncompressed)), MediaType: v1.MediaTypeImageLayer},
+				}
+				sourceDiffIds = []digest.Digest{diffId, uncompressedDigest}
+				layerFiles = map[digest.Digest][]byte{
+					sourceLayers[0].Digest: gzipped,
+					sourceLayers[1].Digest: uncompressed,
+				}
+
+				sourceConfig.RootFS.DiffIDs = sourceDiffIds
+				registry.ManifestReturnsOnCall(0, v1.Manifest{Layers: sourceLayers, Config: manifestConfig}, manifestDigest, rawManifest, nil)
+				registry.ConfigReturnsOnCall(0, sourceConfig, rawConfig, nil)
+				registry.DownloadLayerStub = func(_ context.Context, l v1.Descriptor, dir string) error {
+					return os.WriteFile(filepath.Join(dir, l.Digest.Encoded()), layerFiles[l.Digest], 0644)
+				}
+
+				d = downloader.New(log.New(io.MultiWriter(GinkgoWriter, logBuffer), "", 0), layerDir, registry, downloader.Options{Retry: &retryPolicy, VerifyDiffIDs: true})
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(layerDir)).To(Succeed())
+			})
+
+			It("succeeds when the layers match their diffIDs", func() {
+				image, err := d.Run(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(image.DiffIDs).To(Equal(sourceDiffIds))
+			})
+
+			Context("a compressed layer does not match its diffID", func() {
+				BeforeEach(func() {
+					gzipped, _ := gzipLayer("other-tar-data")
+					layerFiles[sourceLayers[0].Digest] = gzipped
+				})
+
+				It("returns an error naming the layer without retrying", func() {
+					_, err := d.Run(context.Background())
+
+					var mismatch *downloader.DiffIDMismatchError
+					Expect(errors.As(err, &mismatch)).To(BeTrue())
+					Expect(mismatch.SHA).To(Equal(sourceLayers[0].Digest.Encoded()))
+					Expect(mismatch.DiffID).To(Equal(sourceDiffIds[0].Encoded()))
+
+					downloads := 0
+					for i := 0; i < registry.DownloadLayerCallCount(); i++ {
+						if _, l, _ := registry.DownloadLayerArgsForCall(i); l.Digest == sourceLayers[0].Digest {
+							downloads++
+						}
+					}
+					Expect(downloads).To(Equal(1))
+				})
+			})
+
+			Context("an uncompressed layer does not match its diffID", func() {
+				BeforeEach(func() {
+					sourceConfig.RootFS.DiffIDs = []digest.Digest{sourceDiffIds[0], digest.FromString("something-else")}
+					registry.ConfigReturnsOnCall(0, sourceConfig, rawConfig, nil)
+				})
+
+				It("returns an error naming the layer", func() {
+					_, err := d.Run(context.Background())
+
+					var mismatch *downloader.DiffIDMismatchError
+					Expect(errors.As(err, &mismatch)).To(BeTrue())
+					Expect(mismatch.SHA).To(Equal(sourceLayers[1].Digest.Encoded()))
+				})
+			})
+
+			Context("the layer is not in the download directory", func() {
+				BeforeEach(func() {
+					registry.DownloadLayerStub = func(context.Context, v1.Descriptor, string) error {
+						return nil
+					}
+				})
+
+				It("returns an error naming the layer", func() {
+					_, err := d.Run(context.Background())
+
+					var layerErr *downloader.LayerDownloadError
+					Expect(errors.As(err, &layerErr)).To(BeTrue())
+					Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+				})
+			})
+
+			Context("the context is cancelled once a layer is downloaded", func() {
+				var (
+					ctx      context.Context
+					cancel   context.CancelFunc
+					reporter *progressfakes.Reporter
+				)
+
+				BeforeEach(func() {
+					ctx, cancel = context.WithCancel(context.Background())
+					registry.DownloadLayerStub = func(_ context.Context, l v1.Descriptor, dir string) error {
+						defer cancel()
+						return os.WriteFile(filepath.Join(dir, l.Digest.Encoded()), layerFiles[l.Digest], 0644)
+					}
+
+					reporter = &progressfakes.Reporter{}
+					d = downloader.New(log.New(io.MultiWriter(GinkgoWriter, logBuffer), "", 0), layerDir, registry, downloader.Options{Retry: &retryPolicy, VerifyDiffIDs: true, Progress: reporter, Parallelism: 1})
+				})
+
+				It("stops decompressing it and returns the context error", func() {
+					_, err := d.Run(ctx)
+					Expect(err).To(MatchError(context.Canceled))
+
+					Expect(reporter.FinishCallCount()).To(Equal(1))
+					blob, finishErr := reporter.FinishArgsForCall(0)
+					Expect(blob).To(Equal(sourceLayers[0].Digest))
+					Expect(finishErr).To(MatchError(context.Canceled))
+				})
+			})
+
+			Context("a compressed layer is not gzipped", func() {
+				BeforeEach(func() {
+					layerFiles[sourceLayers[0].Digest] = []byte("not-gzipped")
+				})
+
+				It("returns an error naming the layer", func() {
+					_, err := d.Run(context.Background())
+
+					var layerErr *downloader.LayerDownloadError
+					Expect(errors.As(err, &layerErr)).To(BeTrue())
+					Expect(layerErr.SHA).To(Equal(sourceLayers[0].Digest.Encoded()))
+				})
+			})
+		})
+
+		Context("there are more layers than the parallelism", func() {
+			var (
+				active    int32
+				maxActive int32
+			)
+
+			BeforeEach(func() {
+				sourceLayers = nil
+				sourceDiffIds = nil
+				for i := 0; i < 8; i++ {
+					sourceLayers = append(sourceLayers, v1.Descriptor{Digest: digest.NewDigestFromEncoded(digest.SHA256, fmt.Sprintf("layer%d", i))})
+					sourceDiffIds = append(sourceDiffIds, digest.NewDigestFromEncoded(digest.SHA256, fmt.Sprintf("diffid%d", i)))
+				}
+				registry.ManifestReturnsOnCall(0, v1.Manifest{Layers: sourceLayers, Config: manifestConfig}, manifestDigest, rawManifest, nil)
+				sourceConfig.RootFS.DiffIDs = sourceDiffIds
+				registry.ConfigReturnsOnCall(0, sourceConfig, rawConfig, nil)
+
+				atomic.StoreInt32(&active, 0)
+				atomic.StoreInt32(&maxActive, 0)
+				registry.DownloadLayerStub = func(context.Context, v1.Descriptor, string) error {
+					n := atomic.AddInt32(&active, 1)
+					defer atomic.AddInt32(&active, -1)
+					for {
+						m := atomic.LoadInt32(&maxActive)
+						if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+							break
+						}
+					}
+					time.Sleep(20 * time.Millisecond)
+					return nil
+				}
+
+				d = downloader.New(log.New(io.MultiWriter(GinkgoWriter, logBuffer), "", 0), downloadDir, registry, downloader.Options{Parallelism: 2, Retry: &retryPolicy})
+			})
+
+			It("downloads at most that many layers at once", func() {
+				image, err := d.Run(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(image.Layers).To(HaveLen(8))
+
+				Expect(registry.DownloadLayerCallCount()).To(Equal(8))
+				Expect(atomic.LoadInt32(&maxActive)).To(Equal(int32(2)))
+			})
+
+			Context("downloads are retried", func() {
+				BeforeEach(func() {
+					stub := registry.DownloadLayerStub
+					registry.DownloadLayerStub = func(ctx context.Context, layer v1.Descriptor, dir string) error {
+						stub(ctx, layer, dir)
+						if registry.DownloadLayerCallCount() <= 2 {
+							return transientError("couldn't download layer")
+						}
+						return nil
+					}
+				})
+
+				It("counts the retries towards the limit", func() {
+					_, err := d.Run(context.Background())
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(registry.DownloadLayerCallCount()).To(Equal(10))
+					Expect(atomic.LoadInt32(&maxActive)).To(Equal(int32(2)))
+				})
+			})
+		})
+
 		Context("downloading a layer fails inconsistently", func() {
 			BeforeEach(func() {
-				registry.DownloadLayerReturnsOnCall(0, errors.New("couldn't download layer error 1"))
-				registry.DownloadLayerReturnsOnCall(1, errors.New("couldn't download layer error 2"))
-				registry.DownloadLayerReturnsOnCall(2, errors.New("couldn't download layer error 3"))
+				registry.DownloadLayerReturnsOnCall(0, transientError("couldn't download layer error 1"))
+				registry.DownloadLayerReturnsOnCall(1, transientError("couldn't download layer error 2"))
+				registry.DownloadLayerReturnsOnCall(2, transientError("couldn't download layer error 3"))
 			})
 
 			It("retries and succeeds", func() {
-				layers, _, err := d.Run()
+				image, err := d.Run(context.Background())
 				Expect(err).NotTo(HaveOccurred())
 
-				Expect(layers[0].Digest).To(Equal(digest.Digest("sha256:layer1")))
+				Expect(image.Layers[0].Digest).To(Equal(digest.Digest("sha256:layer1")))
 				Expect(registry.DownloadLayerCallCount()).To(Equal(5))
+				Expect(registry.RemovePartialCallCount()).To(Equal(0))
 			})
 
 			It("logs the retries", func() {
-				layers, _, err := d.Run()
+				image, err := d.Run(context.Background())
 				Expect(err).NotTo(HaveOccurred())
 
-				Expect(layers[0].Digest).To(Equal(digest.Digest("sha256:layer1")))
+				Expect(image.Layers[0].Digest).To(Equal(digest.Digest("sha256:layer1")))
 
 				Expect(logBuffer.String()).To(MatchRegexp("Attempt [0-9] failed downloading layer with diffID: [[:alnum:]]+, sha256: [[:alnum:]]+: couldn't download layer error 1\n"))
 				Expect(logBuffer.String()).To(MatchRegexp("Attempt [0-9] failed downloading layer with diffID: [[:alnum:]]+, sha256: [[:alnum:]]+: couldn't download layer error 2\n"))
@@ -118,24 +415,207 @@ var _ = Describe("Downloader", func() {
 
 		Context("downloading a layer fails every time", func() {
 			BeforeEach(func() {
-				registry.DownloadLayerReturns(errors.New("couldn't download layer"))
+				registry.DownloadLayerReturns(transientError("couldn't download layer"))
 			})
 
 			It("retries each layer the max number of times and then returns a descriptive error", func() {
-				_, _, err := d.Run()
-				Expect(err).To(BeAssignableToTypeOf(&downloader.MaxLayerDownloadRetriesError{}))
+				_, err := d.Run(context.Background())
+				var maxRetriesErr *downloader.MaxLayerDownloadRetriesError
+				Expect(errors.As(err, &maxRetriesErr)).To(BeTrue())
 				Expect(registry.DownloadLayerCallCount()).To(BeNumerically(">=", 5))
 			})
+
+			It("removes the partial layer after the last attempt", func() {
+				_, err := d.Run(context.Background())
+				Expect(err).To(HaveOccurred())
+
+				Expect(registry.RemovePartialCallCount()).To(BeNumerically(">=", 1))
+				l, dir := registry.RemovePartialArgsForCall(0)
+				Expect(sourceLayers).To(ContainElement(l))
+				Expect(dir).To(Equal(downloadDir))
+			})
+		})
+
+		Context("a layer fails while the others are downloading", func() {
+			var active int32
+
+			BeforeEach(func() {
+				atomic.StoreInt32(&active, 0)
+				registry.DownloadLayerStub = func(ctx context.Context, layer v1.Descriptor, _ string) error {
+					atomic.AddInt32(&active, 1)
+					defer atomic.AddInt32(&active, -1)
+
+					if layer.Digest == sourceLayers[0].Digest {
+						time.Sleep(50 * time.Millisecond)
+						return errors.New("not found")
+					}
+
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-time.After(10 * time.Second):
+						return nil
+					}
+				}
+			})
+
+			It("cancels the other downloads and waits for them before returning the error", func() {
+				start := time.Now()
+				_, err := d.Run(context.Background())
+				Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+
+				Expect(err).To(BeAssignableToTypeOf(&downloader.LayerDownloadError{}))
+				Expect(err.(*downloader.LayerDownloadError).SHA).To(Equal(sourceLayers[0].Digest.Encoded()))
+				Expect(atomic.LoadInt32(&active)).To(BeZero())
+				Expect(registry.DownloadLayerCallCount()).To(Equal(2))
+			})
+		})
+
+		Context("several layers fail at the same time", func() {
+			BeforeEach(func() {
+				var started sync.WaitGroup
+				started.Add(2)
+				registry.DownloadLayerStub = func(_ context.Context, layer v1.Descriptor, _ string) error {
+					started.Done()
+					started.Wait()
+					return fmt.Errorf("%s not found", layer.Digest.Encoded())
+				}
+			})
+
+			It("returns all of the errors", func() {
+				_, err := d.Run(context.Background())
+				Expect(err).To(BeAssignableToTypeOf(&downloader.LayerDownloadErrors{}))
+				Expect(err.(*downloader.LayerDownloadErrors).Errors).To(HaveLen(2))
+				Expect(err).To(MatchError(ContainSubstring("layer1 not found")))
+				Expect(err).To(MatchError(ContainSubstring("layer2 not found")))
+			})
+		})
+	})
+
+	Context("the context is cancelled while downloading", func() {
+		var (
+			ctx    context.Context
+			cancel context.CancelFunc
+		)
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+			registry.DownloadLayerStub = func(ctx context.Context, _ v1.Descriptor, _ string) error {
+				cancel()
+				return ctx.Err()
+			}
+		})
+
+		It("stops without retrying and returns the context error", func() {
+			_, err := d.Run(ctx)
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(registry.DownloadLayerCallCount()).To(BeNumerically("<=", 2))
+			Expect(logBuffer.String()).NotTo(ContainSubstring("Attempt"))
+		})
+
+		It("removes the partial layers", func() {
+			_, err := d.Run(ctx)
+			Expect(err).To(HaveOccurred())
+			Expect(registry.RemovePartialCallCount()).To(Equal(registry.DownloadLayerCallCount()))
+		})
+	})
+
+	Context("the context is cancelled while waiting to retry", func() {
+		var (
+			ctx    context.Context
+			cancel context.CancelFunc
+		)
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+			retryPolicy.InitialBackoff = 5 * time.Second
+			d = downloader.New(log.New(io.MultiWriter(GinkgoWriter, logBuffer), "", 0), downloadDir, registry, downloader.Options{Retry: &retryPolicy})
+			registry.DownloadLayerStub = func(context.Context, v1.Descriptor, string) error {
+				time.AfterFunc(100*time.Millisecond, cancel)
+				return transientError("couldn't download layer")
+			}
+		})
+
+		It("returns the context error without waiting for the retries", func() {
+			start := time.Now()
+			_, err := d.Run(ctx)
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			Expect(registry.DownloadLayerCallCount()).To(Equal(2))
+		})
+	})
+
+	Context("downloading a layer fails with an error that is not retryable", func() {
+		var downloadErr error
+
+		BeforeEach(func() {
+			downloadErr = errors.New("not found")
+			registry.DownloadLayerReturns(downloadErr)
+		})
+
+		It("does not retry and returns the error", func() {
+			_, err := d.Run(context.Background())
+			Expect(err).To(BeAssignableToTypeOf(&downloader.LayerDownloadError{}))
+			Expect(errors.Is(err, downloadErr)).To(BeTrue())
+			Expect(registry.DownloadLayerCallCount()).To(BeNumerically("<=", 2))
+			Expect(logBuffer.String()).NotTo(ContainSubstring("Attempt 2"))
+		})
+
+		Context("the retry policy classifies it as retryable", func() {
+			BeforeEach(func() {
+				retryPolicy.MaxAttempts = 3
+				retryPolicy.Retryable = func(err error) bool { return err == downloadErr }
+				d = downloader.New(log.New(io.MultiWriter(GinkgoWriter, logBuffer), "", 0), downloadDir, registry, downloader.Options{Retry: &retryPolicy})
+			})
+
+			It("retries up to the max attempts", func() {
+				_, err := d.Run(context.Background())
+				Expect(err).To(BeAssignableToTypeOf(&downloader.MaxLayerDownloadRetriesError{}))
+				Expect(errors.Is(err, downloadErr)).To(BeTrue())
+				Expect(registry.DownloadLayerCallCount()).To(BeNumerically(">=", 3))
+			})
+		})
+	})
+
+	Context("the registry asks to retry after a delay", func() {
+		BeforeEach(func() {
+			registry.DownloadLayerReturnsOnCall(0, retryAfterError(300*time.Millisecond))
+		})
+
+		It("waits at least that long before retrying", func() {
+			start := time.Now()
+			_, err := d.Run(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically(">=", 300*time.Millisecond))
+			Expect(registry.DownloadLayerCallCount()).To(Equal(3))
+		})
+
+		Context("the delay is longer than the max backoff", func() {
+			BeforeEach(func() {
+				registry.DownloadLayerReturnsOnCall(0, retryAfterError(time.Hour))
+			})
+
+			It("does not retry and returns an error naming the delay", func() {
+				start := time.Now()
+				_, err := d.Run(context.Background())
+				Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+
+				var tooLong *downloader.RetryAfterTooLongError
+				Expect(errors.As(err, &tooLong)).To(BeTrue())
+				Expect(tooLong.RetryAfter).To(Equal(time.Hour))
+				Expect(err.Error()).To(ContainSubstring("wait 1h0m0s"))
+				Expect(errors.Is(err, retryAfterError(time.Hour))).To(BeTrue())
+			})
 		})
 	})
 
 	Context("getting the manifest fails", func() {
 		BeforeEach(func() {
-			registry.ManifestReturnsOnCall(0, v1.Manifest{}, errors.New("couldn't get manifest"))
+			registry.ManifestReturnsOnCall(0, v1.Manifest{}, "", nil, errors.New("couldn't get manifest"))
 		})
 
 		It("returns an error", func() {
-			_, _, err := d.Run()
+			_, err := d.Run(context.Background())
 			Expect(err.Error()).To(Equal("couldn't get manifest"))
 			Expect(registry.DownloadLayerCallCount()).To(Equal(0))
 		})
@@ -154,11 +634,11 @@ var _ = Describe("Downloader", func() {
 				RootFS: v1.RootFS{Type: "layers", DiffIDs: sourceDiffIds},
 			}
 
-			registry.ConfigReturnsOnCall(0, sourceConfig, nil)
+			registry.ConfigReturnsOnCall(0, sourceConfig, rawConfig, nil)
 		})
 
 		It("returns an error", func() {
-			_, _, err := d.Run()
+			_, err := d.Run(context.Background())
 			Expect(err.Error()).To(Equal("mismatch: 2 layers, 1 diffIds"))
 			Expect(registry.DownloadLayerCallCount()).To(Equal(0))
 		})
@@ -172,11 +652,11 @@ var _ = Describe("Downloader", func() {
 				},
 			}
 
-			registry.ConfigReturnsOnCall(0, sourceConfig, nil)
+			registry.ConfigReturnsOnCall(0, sourceConfig, rawConfig, nil)
 		})
 
 		It("returns an error", func() {
-			_, _, err := d.Run()
+			_, err := d.Run(context.Background())
 			Expect(err.Error()).To(Equal("invalid container OS: linux"))
 			Expect(registry.DownloadLayerCallCount()).To(Equal(0))
 		})
@@ -191,13 +671,91 @@ var _ = Describe("Downloader", func() {
 				},
 			}
 
-			registry.ConfigReturnsOnCall(0, sourceConfig, nil)
+			registry.ConfigReturnsOnCall(0, sourceConfig, rawConfig, nil)
 		})
 
 		It("returns an error", func() {
-			_, _, err := d.Run()
+			_, err := d.Run(context.Background())
 			Expect(err.Error()).To(Equal("invalid container arch: ppc64"))
 			Expect(registry.DownloadLayerCallCount()).To(Equal(0))
 		})
 	})
+
+	Context("another platform is requested", func() {
+		BeforeEach(func() {
+			sourceConfig.Platform = v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+			registry.ConfigReturnsOnCall(0, sourceConfig, rawConfig, nil)
+
+			d = downloader.New(log.New(io.MultiWriter(GinkgoWriter, logBuffer), "", 0), downloadDir, registry, downloader.Options{
+				Retry:    &retryPolicy,
+				Platform: v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+			})
+		})
+
+		It("accepts a config for that platform and returns its platform", func() {
+			image, err := d.Run(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(image.Config.Platform).To(Equal(v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}))
+			Expect(registry.DownloadLayerCallCount()).To(Equal(2))
+		})
+
+		Context("the config has no variant", func() {
+			BeforeEach(func() {
+				sourceConfig.Variant = ""
+				registry.ConfigReturnsOnCall(0, sourceConfig, rawConfig, nil)
+			})
+
+			It("accepts the config", func() {
+				_, err := d.Run(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("the config has a different variant", func() {
+			BeforeEach(func() {
+				sourceConfig.Variant = "v7"
+				registry.ConfigReturnsOnCall(0, sourceConfig, rawConfig, nil)
+			})
+
+			It("returns an error", func() {
+				_, err := d.Run(context.Background())
+				Expect(err.Error()).To(Equal("invalid container variant: v7"))
+				Expect(registry.DownloadLayerCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("the config is for the default platform", func() {
+			BeforeEach(func() {
+				sourceConfig.Platform = v1.Platform{OS: "windows", Architecture: "amd64"}
+				registry.ConfigReturnsOnCall(0, sourceConfig, rawConfig, nil)
+			})
+
+			It("returns an error", func() {
+				_, err := d.Run(context.Background())
+				Expect(err.Error()).To(Equal("invalid container OS: windows"))
+				Expect(registry.DownloadLayerCallCount()).To(Equal(0))
+			})
+		})
+	})
 })
+
+type transientError string
+
+func (e transientError) Error() string   { return string(e) }
+func (e transientError) Retryable() bool { return true }
+
+type retryAfterError time.Duration
+
+func (e retryAfterError) Error() string             { return "too many requests" }
+func (e retryAfterError) Retryable() bool           { return true }
+func (e retryAfterError) RetryAfter() time.Duration { return time.Duration(e) }
+
+func gzipLayer(contents string) ([]byte, digest.Digest) {
+	buffer := new(bytes.Buffer)
+	gz := gzip.NewWriter(buffer)
+	_, err := gz.Write([]byte(contents))
+	Expect(err).NotTo(HaveOccurred())
+	Expect(gz.Close()).To(Succeed())
+
+	return buffer.Bytes(), digest.FromString(contents)
+}
@@ -1,12 +1,104 @@
 package downloader
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 type MaxLayerDownloadRetriesError struct {
 	DiffID string
 	SHA    string
+	// Cause is the error from the last attempt
+	Cause error
 }
 
 func (e *MaxLayerDownloadRetriesError) Error() string {
 	return fmt.Sprintf("Exceeded maximum download attempts for blob with diffID: %.8s, sha256: %.8s", e.DiffID, e.SHA)
 }
+
+func (e *MaxLayerDownloadRetriesError) Unwrap() error {
+	return e.Cause
+}
+
+// RetryAfterTooLongError is returned instead of retrying when the registry
+// asks to wait for longer than the maximum backoff
+type RetryAfterTooLongError struct {
+	DiffID     string
+	SHA        string
+	RetryAfter time.Duration
+	MaxBackoff time.Duration
+	Cause      error
+}
+
+func (e *RetryAfterTooLongError) Error() string {
+	return fmt.Sprintf("Registry asked to wait %s before downloading blob with diffID: %.8s, sha256: %.8s again, longer than the maximum backoff of %s", e.RetryAfter, e.DiffID, e.SHA, e.MaxBackoff)
+}
+
+func (e *RetryAfterTooLongError) Unwrap() error {
+	return e.Cause
+}
+
+// LayerDownloadError is returned without retrying for errors that retrying will not fix
+type LayerDownloadError struct {
+	DiffID string
+	SHA    string
+	Cause  error
+}
+
+func (e *LayerDownloadError) Error() string {
+	return fmt.Sprintf("Failed downloading blob with diffID: %.8s, sha256: %.8s: %s", e.DiffID, e.SHA, e.Cause.Error())
+}
+
+func (e *LayerDownloadError) Unwrap() error {
+	return e.Cause
+}
+
+// DiffIDMismatchError is returned when a decompressed layer does not match
+// the diffID in the image config. Downloading it again will not help.
+type DiffIDMismatchError struct {
+	DiffID string
+	SHA    string
+	// Actual is the digest of the decompressed layer, including the algorithm
+	Actual string
+}
+
+func (e *DiffIDMismatchError) Error() string {
+	return fmt.Sprintf("Layer with sha256: %.8s does not match its diffID: expected %s, decompressed layer is %s", e.SHA, e.DiffID, e.Actual)
+}
+
+// LayerDownloadErrors is returned when more than one layer failed before the
+// other downloads were cancelled
+type LayerDownloadErrors struct {
+	Errors []error
+}
+
+func (e *LayerDownloadErrors) Error() string {
+	messages := []string{}
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("Failed downloading %d layers: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+func (e *LayerDownloadErrors) Unwrap() []error {
+	return e.Errors
+}
+
+func newLayerDownloadErrors(layerErrs []error) error {
+	errs := []error{}
+	for _, err := range layerErrs {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return &LayerDownloadErrors{Errors: errs}
+	}
+}
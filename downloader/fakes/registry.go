@@ -2,31 +2,37 @@
 package fakes
 
 import (
-	sync "sync"
+	"context"
+	"sync"
 
-	downloader "code.cloudfoundry.org/hydrator/downloader"
+	"code.cloudfoundry.org/hydrator/downloader"
+	digest "github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type Registry struct {
-	ConfigStub        func(v1.Descriptor) (v1.Image, error)
+	ConfigStub        func(context.Context, v1.Descriptor) (v1.Image, []byte, error)
 	configMutex       sync.RWMutex
 	configArgsForCall []struct {
-		arg1 v1.Descriptor
+		arg1 context.Context
+		arg2 v1.Descriptor
 	}
 	configReturns struct {
 		result1 v1.Image
-		result2 error
+		result2 []byte
+		result3 error
 	}
 	configReturnsOnCall map[int]struct {
 		result1 v1.Image
-		result2 error
+		result2 []byte
+		result3 error
 	}
-	DownloadLayerStub        func(v1.Descriptor, string) error
+	DownloadLayerStub        func(context.Context, v1.Descriptor, string) error
 	downloadLayerMutex       sync.RWMutex
 	downloadLayerArgsForCall []struct {
-		arg1 v1.Descriptor
-		arg2 string
+		arg1 context.Context
+		arg2 v1.Descriptor
+		arg3 string
 	}
 	downloadLayerReturns struct {
 		result1 error
@@ -34,38 +40,57 @@ type Registry struct {
 	downloadLayerReturnsOnCall map[int]struct {
 		result1 error
 	}
-	ManifestStub        func() (v1.Manifest, error)
+	ManifestStub        func(context.Context) (v1.Manifest, digest.Digest, []byte, error)
 	manifestMutex       sync.RWMutex
 	manifestArgsForCall []struct {
+		arg1 context.Context
 	}
 	manifestReturns struct {
 		result1 v1.Manifest
-		result2 error
+		result2 digest.Digest
+		result3 []byte
+		result4 error
 	}
 	manifestReturnsOnCall map[int]struct {
 		result1 v1.Manifest
-		result2 error
+		result2 digest.Digest
+		result3 []byte
+		result4 error
+	}
+	RemovePartialStub        func(v1.Descriptor, string) error
+	removePartialMutex       sync.RWMutex
+	removePartialArgsForCall []struct {
+		arg1 v1.Descriptor
+		arg2 string
+	}
+	removePartialReturns struct {
+		result1 error
+	}
+	removePartialReturnsOnCall map[int]struct {
+		result1 error
 	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *Registry) Config(arg1 v1.Descriptor) (v1.Image, error) {
+func (fake *Registry) Config(arg1 context.Context, arg2 v1.Descriptor) (v1.Image, []byte, error) {
 	fake.configMutex.Lock()
 	ret, specificReturn := fake.configReturnsOnCall[len(fake.configArgsForCall)]
 	fake.configArgsForCall = append(fake.configArgsForCall, struct {
-		arg1 v1.Descriptor
-	}{arg1})
-	fake.recordInvocation("Config", []interface{}{arg1})
+		arg1 context.Context
+		arg2 v1.Descriptor
+	}{arg1, arg2})
+	stub := fake.ConfigStub
+	fakeReturns := fake.configReturns
+	fake.recordInvocation("Config", []interface{}{arg1, arg2})
 	fake.configMutex.Unlock()
-	if fake.ConfigStub != nil {
-		return fake.ConfigStub(arg1)
+	if stub != nil {
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
-		return ret.result1, ret.result2
+		return ret.result1, ret.result2, ret.result3
 	}
-	fakeReturns := fake.configReturns
-	return fakeReturns.result1, fakeReturns.result2
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
 }
 
 func (fake *Registry) ConfigCallCount() int {
@@ -74,61 +99,66 @@ func (fake *Registry) ConfigCallCount() int {
 	return len(fake.configArgsForCall)
 }
 
-func (fake *Registry) ConfigCalls(stub func(v1.Descriptor) (v1.Image, error)) {
+func (fake *Registry) ConfigCalls(stub func(context.Context, v1.Descriptor) (v1.Image, []byte, error)) {
 	fake.configMutex.Lock()
 	defer fake.configMutex.Unlock()
 	fake.ConfigStub = stub
 }
 
-func (fake *Registry) ConfigArgsForCall(i int) v1.Descriptor {
+func (fake *Registry) ConfigArgsForCall(i int) (context.Context, v1.Descriptor) {
 	fake.configMutex.RLock()
 	defer fake.configMutex.RUnlock()
 	argsForCall := fake.configArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
-func (fake *Registry) ConfigReturns(result1 v1.Image, result2 error) {
+func (fake *Registry) ConfigReturns(result1 v1.Image, result2 []byte, result3 error) {
 	fake.configMutex.Lock()
 	defer fake.configMutex.Unlock()
 	fake.ConfigStub = nil
 	fake.configReturns = struct {
 		result1 v1.Image
-		result2 error
-	}{result1, result2}
+		result2 []byte
+		result3 error
+	}{result1, result2, result3}
 }
 
-func (fake *Registry) ConfigReturnsOnCall(i int, result1 v1.Image, result2 error) {
+func (fake *Registry) ConfigReturnsOnCall(i int, result1 v1.Image, result2 []byte, result3 error) {
 	fake.configMutex.Lock()
 	defer fake.configMutex.Unlock()
 	fake.ConfigStub = nil
 	if fake.configReturnsOnCall == nil {
 		fake.configReturnsOnCall = make(map[int]struct {
 			result1 v1.Image
-			result2 error
+			result2 []byte
+			result3 error
 		})
 	}
 	fake.configReturnsOnCall[i] = struct {
 		result1 v1.Image
-		result2 error
-	}{result1, result2}
+		result2 []byte
+		result3 error
+	}{result1, result2, result3}
 }
 
-func (fake *Registry) DownloadLayer(arg1 v1.Descriptor, arg2 string) error {
+func (fake *Registry) DownloadLayer(arg1 context.Context, arg2 v1.Descriptor, arg3 string) error {
 	fake.downloadLayerMutex.Lock()
 	ret, specificReturn := fake.downloadLayerReturnsOnCall[len(fake.downloadLayerArgsForCall)]
 	fake.downloadLayerArgsForCall = append(fake.downloadLayerArgsForCall, struct {
-		arg1 v1.Descriptor
-		arg2 string
-	}{arg1, arg2})
-	fake.recordInvocation("DownloadLayer", []interface{}{arg1, arg2})
+		arg1 context.Context
+		arg2 v1.Descriptor
+		arg3 string
+	}{arg1, arg2, arg3})
+	stub := fake.DownloadLayerStub
+	fakeReturns := fake.downloadLayerReturns
+	fake.recordInvocation("DownloadLayer", []interface{}{arg1, arg2, arg3})
 	fake.downloadLayerMutex.Unlock()
-	if fake.DownloadLayerStub != nil {
-		return fake.DownloadLayerStub(arg1, arg2)
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.downloadLayerReturns
 	return fakeReturns.result1
 }
 
@@ -138,17 +168,17 @@ func (fake *Registry) DownloadLayerCallCount() int {
 	return len(fake.downloadLayerArgsForCall)
 }
 
-func (fake *Registry) DownloadLayerCalls(stub func(v1.Descriptor, string) error) {
+func (fake *Registry) DownloadLayerCalls(stub func(context.Context, v1.Descriptor, string) error) {
 	fake.downloadLayerMutex.Lock()
 	defer fake.downloadLayerMutex.Unlock()
 	fake.DownloadLayerStub = stub
 }
 
-func (fake *Registry) DownloadLayerArgsForCall(i int) (v1.Descriptor, string) {
+func (fake *Registry) DownloadLayerArgsForCall(i int) (context.Context, v1.Descriptor, string) {
 	fake.downloadLayerMutex.RLock()
 	defer fake.downloadLayerMutex.RUnlock()
 	argsForCall := fake.downloadLayerArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
 func (fake *Registry) DownloadLayerReturns(result1 error) {
@@ -174,21 +204,23 @@ func (fake *Registry) DownloadLayerReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *Registry) Manifest() (v1.Manifest, error) {
+func (fake *Registry) Manifest(arg1 context.Context) (v1.Manifest, digest.Digest, []byte, error) {
 	fake.manifestMutex.Lock()
 	ret, specificReturn := fake.manifestReturnsOnCall[len(fake.manifestArgsForCall)]
 	fake.manifestArgsForCall = append(fake.manifestArgsForCall, struct {
-	}{})
-	fake.recordInvocation("Manifest", []interface{}{})
+		arg1 context.Context
+	}{arg1})
+	stub := fake.ManifestStub
+	fakeReturns := fake.manifestReturns
+	fake.recordInvocation("Manifest", []interface{}{arg1})
 	fake.manifestMutex.Unlock()
-	if fake.ManifestStub != nil {
-		return fake.ManifestStub()
+	if stub != nil {
+		return stub(arg1)
 	}
 	if specificReturn {
-		return ret.result1, ret.result2
+		return ret.result1, ret.result2, ret.result3, ret.result4
 	}
-	fakeReturns := fake.manifestReturns
-	return fakeReturns.result1, fakeReturns.result2
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3, fakeReturns.result4
 }
 
 func (fake *Registry) ManifestCallCount() int {
@@ -197,47 +229,116 @@ func (fake *Registry) ManifestCallCount() int {
 	return len(fake.manifestArgsForCall)
 }
 
-func (fake *Registry) ManifestCalls(stub func() (v1.Manifest, error)) {
+func (fake *Registry) ManifestCalls(stub func(context.Context) (v1.Manifest, digest.Digest, []byte, error)) {
 	fake.manifestMutex.Lock()
 	defer fake.manifestMutex.Unlock()
 	fake.ManifestStub = stub
 }
 
-func (fake *Registry) ManifestReturns(result1 v1.Manifest, result2 error) {
+func (fake *Registry) ManifestArgsForCall(i int) context.Context {
+	fake.manifestMutex.RLock()
+	defer fake.manifestMutex.RUnlock()
+	argsForCall := fake.manifestArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *Registry) ManifestReturns(result1 v1.Manifest, result2 digest.Digest, result3 []byte, result4 error) {
 	fake.manifestMutex.Lock()
 	defer fake.manifestMutex.Unlock()
 	fake.ManifestStub = nil
 	fake.manifestReturns = struct {
 		result1 v1.Manifest
-		result2 error
-	}{result1, result2}
+		result2 digest.Digest
+		result3 []byte
+		result4 error
+	}{result1, result2, result3, result4}
 }
 
-func (fake *Registry) ManifestReturnsOnCall(i int, result1 v1.Manifest, result2 error) {
+func (fake *Registry) ManifestReturnsOnCall(i int, result1 v1.Manifest, result2 digest.Digest, result3 []byte, result4 error) {
 	fake.manifestMutex.Lock()
 	defer fake.manifestMutex.Unlock()
 	fake.ManifestStub = nil
 	if fake.manifestReturnsOnCall == nil {
 		fake.manifestReturnsOnCall = make(map[int]struct {
 			result1 v1.Manifest
-			result2 error
+			result2 digest.Digest
+			result3 []byte
+			result4 error
 		})
 	}
 	fake.manifestReturnsOnCall[i] = struct {
 		result1 v1.Manifest
-		result2 error
-	}{result1, result2}
+		result2 digest.Digest
+		result3 []byte
+		result4 error
+	}{result1, result2, result3, result4}
+}
+
+func (fake *Registry) RemovePartial(arg1 v1.Descriptor, arg2 string) error {
+	fake.removePartialMutex.Lock()
+	ret, specificReturn := fake.removePartialReturnsOnCall[len(fake.removePartialArgsForCall)]
+	fake.removePartialArgsForCall = append(fake.removePartialArgsForCall, struct {
+		arg1 v1.Descriptor
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.RemovePartialStub
+	fakeReturns := fake.removePartialReturns
+	fake.recordInvocation("RemovePartial", []interface{}{arg1, arg2})
+	fake.removePartialMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *Registry) RemovePartialCallCount() int {
+	fake.removePartialMutex.RLock()
+	defer fake.removePartialMutex.RUnlock()
+	return len(fake.removePartialArgsForCall)
+}
+
+func (fake *Registry) RemovePartialCalls(stub func(v1.Descriptor, string) error) {
+	fake.removePartialMutex.Lock()
+	defer fake.removePartialMutex.Unlock()
+	fake.RemovePartialStub = stub
+}
+
+func (fake *Registry) RemovePartialArgsForCall(i int) (v1.Descriptor, string) {
+	fake.removePartialMutex.RLock()
+	defer fake.removePartialMutex.RUnlock()
+	argsForCall := fake.removePartialArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *Registry) RemovePartialReturns(result1 error) {
+	fake.removePartialMutex.Lock()
+	defer fake.removePartialMutex.Unlock()
+	fake.RemovePartialStub = nil
+	fake.removePartialReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *Registry) RemovePartialReturnsOnCall(i int, result1 error) {
+	fake.removePartialMutex.Lock()
+	defer fake.removePartialMutex.Unlock()
+	fake.RemovePartialStub = nil
+	if fake.removePartialReturnsOnCall == nil {
+		fake.removePartialReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.removePartialReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
 }
 
 func (fake *Registry) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
-	fake.configMutex.RLock()
-	defer fake.configMutex.RUnlock()
-	fake.downloadLayerMutex.RLock()
-	defer fake.downloadLayerMutex.RUnlock()
-	fake.manifestMutex.RLock()
-	defer fake.manifestMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -0,0 +1,113 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter randomises each backoff by up to this fraction of it, between 0 and 1
+	Jitter float64
+	// Retryable decides which errors are retried, IsRetryable if nil
+	Retryable func(error) bool
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Jitter:         0.2,
+	}
+}
+
+// Backoff is the delay before the given attempt, starting from 2. The delay
+// doubles each attempt up to MaxBackoff and is never shorter than a
+// Retry-After the registry sent with err.
+func (p RetryPolicy) Backoff(attempt int, err error) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(2, float64(attempt-2))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	delay := time.Duration(backoff)
+	if after := retryAfter(err); after > delay {
+		delay = after
+	}
+	return delay
+}
+
+// waitsTooLong is true when the registry sent a Retry-After longer than
+// MaxBackoff with err. Retrying sooner would only be refused again.
+func (p RetryPolicy) waitsTooLong(err error) bool {
+	return p.MaxBackoff > 0 && retryAfter(err) > p.MaxBackoff
+}
+
+// retryAfter is the delay the registry asked for with err, or zero
+func retryAfter(err error) time.Duration {
+	var r interface{ RetryAfter() time.Duration }
+	if errors.As(err, &r) {
+		return r.RetryAfter()
+	}
+	return 0
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// IsRetryable retries network failures and errors with a Retryable() bool
+// method that returns true, such as 5xx responses and sha256 mismatches from
+// the registry package. TLS and certificate failures are network errors too
+// but fail the same way every time, so they are not retried.
+func IsRetryable(err error) bool {
+	var r interface{ Retryable() bool }
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+
+	if isTLSError(err) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func isTLSError(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalidCert      x509.CertificateInvalidError
+		hostname         x509.HostnameError
+		verification     *tls.CertificateVerificationError
+		recordHeader     tls.RecordHeaderError
+		alert            tls.AlertError
+	)
+	return errors.As(err, &unknownAuthority) ||
+		errors.As(err, &invalidCert) ||
+		errors.As(err, &hostname) ||
+		errors.As(err, &verification) ||
+		errors.As(err, &recordHeader) ||
+		errors.As(err, &alert)
+}
@@ -0,0 +1,74 @@
+package downloader_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"code.cloudfoundry.org/hydrator/downloader"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetryPolicy", func() {
+	var policy downloader.RetryPolicy
+
+	BeforeEach(func() {
+		policy = downloader.RetryPolicy{
+			MaxAttempts:    5,
+			InitialBackoff: time.Second,
+			MaxBackoff:     5 * time.Second,
+		}
+	})
+
+	Describe("Backoff", func() {
+		It("doubles the backoff each attempt up to the max", func() {
+			Expect(policy.Backoff(2, nil)).To(Equal(time.Second))
+			Expect(policy.Backoff(3, nil)).To(Equal(2 * time.Second))
+			Expect(policy.Backoff(4, nil)).To(Equal(4 * time.Second))
+			Expect(policy.Backoff(5, nil)).To(Equal(5 * time.Second))
+			Expect(policy.Backoff(50, nil)).To(Equal(5 * time.Second))
+		})
+
+		It("randomises the backoff by the jitter", func() {
+			policy.Jitter = 0.5
+			for i := 0; i < 100; i++ {
+				Expect(policy.Backoff(3, nil)).To(BeNumerically("~", 2*time.Second, time.Second))
+			}
+		})
+
+		It("waits for at least the Retry-After of the error", func() {
+			Expect(policy.Backoff(2, retryAfterError(3*time.Second))).To(Equal(3 * time.Second))
+			Expect(policy.Backoff(2, fmt.Errorf("wrapped: %w", retryAfterError(3*time.Second)))).To(Equal(3 * time.Second))
+			Expect(policy.Backoff(2, retryAfterError(time.Millisecond))).To(Equal(time.Second))
+		})
+
+		It("does not cut a Retry-After longer than the max backoff short", func() {
+			Expect(policy.Backoff(2, retryAfterError(time.Hour))).To(Equal(time.Hour))
+		})
+	})
+
+	Describe("IsRetryable", func() {
+		DescribeTable("classifies errors",
+			func(err error, retryable bool) {
+				Expect(downloader.IsRetryable(err)).To(Equal(retryable))
+			},
+			Entry("an error that is retryable", transientError("oops"), true),
+			Entry("a wrapped error that is retryable", fmt.Errorf("wrapped: %w", transientError("oops")), true),
+			Entry("a network error", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, true),
+			Entry("a truncated body", io.ErrUnexpectedEOF, true),
+			Entry("an untrusted certificate", &url.Error{Op: "Get", URL: "https://registry", Err: x509.UnknownAuthorityError{}}, false),
+			Entry("a certificate for another host", &url.Error{Op: "Get", URL: "https://registry", Err: x509.HostnameError{Host: "registry"}}, false),
+			Entry("an expired certificate", &url.Error{Op: "Get", URL: "https://registry", Err: x509.CertificateInvalidError{Reason: x509.Expired}}, false),
+			Entry("a failed certificate verification", &url.Error{Op: "Get", URL: "https://registry", Err: &tls.CertificateVerificationError{Err: errors.New("oops")}}, false),
+			Entry("a server that does not speak TLS", &url.Error{Op: "Get", URL: "https://registry", Err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}}, false),
+			Entry("an unknown error", errors.New("oops"), false),
+		)
+	})
+})
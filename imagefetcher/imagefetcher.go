@@ -1,17 +1,22 @@
 package imagefetcher
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
-	"strings"
+	"time"
 
+	"code.cloudfoundry.org/hydrator/cache"
 	"code.cloudfoundry.org/hydrator/compress"
 	"code.cloudfoundry.org/hydrator/downloader"
 	directory "code.cloudfoundry.org/hydrator/oci-directory"
+	"code.cloudfoundry.org/hydrator/progress"
 	"code.cloudfoundry.org/hydrator/registry"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type ImageFetcher struct {
@@ -19,32 +24,73 @@ type ImageFetcher struct {
 	outDir    string
 	imageName string
 	imageTag  string
-	registry  string
-	noTarball bool
+	opts      Options
 }
 
-func New(logger *log.Logger, outDir, imageName, imageTag, registry string, noTarball bool) *ImageFetcher {
-	if registry == "" {
-		registry = "https://registry.hub.docker.com"
-	}
+type Options struct {
+	// Registry is used when the image reference does not name one
+	Registry    string
+	NoTarball   bool
+	Credentials registry.CredentialProvider
+	// Platform picks an image from a manifest list and is checked against the
+	// image config. An empty OS or Architecture means windows/amd64. For
+	// windows, OSVersion is the build of the host, e.g. 10.0.17763.
+	Platform v1.Platform
+	TLS      registry.TLSOptions
+	// InsecureRegistries are contacted over plain HTTP, e.g. myregistry.local:5000
+	InsecureRegistries []string
+	// RequestTimeout limits each registry request, see registry.Options
+	RequestTimeout time.Duration
+	// Parallelism is the most layers downloaded at once, see downloader.Options
+	Parallelism int
+	// ChunkSize and ChunkParallelism split large layers into Range requests,
+	// see registry.Options
+	ChunkSize        int64
+	ChunkParallelism int
+	// Retry defaults to downloader.DefaultRetryPolicy
+	Retry *downloader.RetryPolicy
+	// Progress is told about the download of each layer
+	Progress progress.Reporter
+	// CacheDir is a blob cache shared with other downloads, see cache.Cache
+	CacheDir string
+	// VerifyDiffIDs checks each decompressed layer against the image config
+	VerifyDiffIDs bool
+	// PreserveDigest writes the manifest and config exactly as the registry
+	// sent them, so that the image keeps its registry digest
+	PreserveDigest bool
+}
+
+func New(logger *log.Logger, outDir, imageName, imageTag string, opts Options) *ImageFetcher {
 	return &ImageFetcher{
 		logger:    logger,
 		outDir:    outDir,
 		imageName: imageName,
 		imageTag:  imageTag,
-		registry:  registry,
-		noTarball: noTarball,
+		opts:      opts,
 	}
 }
 
-func (i *ImageFetcher) Run() error {
+func (i *ImageFetcher) Run(ctx context.Context) error {
 	var imageDownloadDir string
 
+	ref, err := ParseReference(i.imageName, i.imageTag, i.opts.Registry)
+	if err != nil {
+		return err
+	}
+	repository := ref.Context().RepositoryStr()
+	identifier := ref.Identifier()
+	registryServerURL := RegistryURL(ref, i.opts.InsecureRegistries)
+
+	referenceKind := "tag"
+	if isDigest(ref) {
+		referenceKind = "digest"
+	}
+
 	if err := os.MkdirAll(i.outDir, 0755); err != nil {
 		return errors.New("ERROR: Could not create output directory")
 	}
 
-	if i.noTarball {
+	if i.opts.NoTarball {
 		imageDownloadDir = i.outDir
 	} else {
 		tempDir, err := os.MkdirTemp("", "hydrate")
@@ -61,27 +107,72 @@ func (i *ImageFetcher) Run() error {
 		return err
 	}
 
-	r := registry.New(i.registry, i.imageName, i.imageTag)
-	d := downloader.New(i.logger, blobDownloadDir, r)
+	client, err := registry.NewHTTPClient(i.opts.TLS)
+	if err != nil {
+		return err
+	}
 
-	i.logger.Printf("\nDownloading image: %s with tag: %s from registry: %s\n", i.imageName, i.imageTag, i.registry)
-	layers, diffIds, err := d.Run()
+	var blobCache registry.BlobCache
+	if i.opts.CacheDir != "" {
+		c, err := cache.New(i.opts.CacheDir)
+		if err != nil {
+			return fmt.Errorf("Could not create cache dir: %s", err)
+		}
+		blobCache = c
+	}
+
+	platform := i.opts.Platform
+	if platform.OS == "" {
+		platform.OS = downloader.DefaultOS
+	}
+	if platform.Architecture == "" {
+		platform.Architecture = downloader.DefaultArch
+	}
+
+	r := registry.New(registryServerURL, repository, identifier, registry.Options{
+		Credentials:      i.opts.Credentials,
+		Platform:         platform,
+		HTTPClient:       client,
+		RequestTimeout:   i.opts.RequestTimeout,
+		Progress:         i.opts.Progress,
+		Cache:            blobCache,
+		ChunkSize:        i.opts.ChunkSize,
+		ChunkParallelism: i.opts.ChunkParallelism,
+	})
+	d := downloader.New(i.logger, blobDownloadDir, r, downloader.Options{
+		Parallelism:   i.opts.Parallelism,
+		Retry:         i.opts.Retry,
+		Progress:      i.opts.Progress,
+		VerifyDiffIDs: i.opts.VerifyDiffIDs,
+		Platform:      platform,
+	})
+
+	i.logger.Printf("\nDownloading image: %s with %s: %s from registry: %s\n", repository, referenceKind, identifier, registryServerURL)
+	image, err := d.Run(ctx)
 	if err != nil {
-		return fmt.Errorf("Failed downloading image: %s with tag: %s from registry: %s - %s", i.imageName, i.imageTag, i.registry, err)
+		return fmt.Errorf("Failed downloading image: %s with %s: %s from registry: %s - %s", repository, referenceKind, identifier, registryServerURL, err)
+	}
+
+	/* record which image was hydrated, so that it can be pulled again by digest */
+	annotations := map[string]string{
+		"hydrator.sourceImage":  ref.Name(),
+		"hydrator.sourceDigest": image.ManifestDigest.String(),
 	}
 
 	handler := directory.NewHandler(imageDownloadDir)
-	if err := handler.WriteMetadata(layers, diffIds, false); err != nil {
+	if i.opts.PreserveDigest {
+		err = handler.WriteOriginalMetadata(image.RawManifest, image.RawConfig, annotations)
+	} else {
+		err = handler.WriteMetadata(v1.Manifest{Layers: image.Layers, Annotations: annotations}, image.Config, nil)
+	}
+	if err != nil {
 		return err
 	}
 	i.logger.Printf("\nAll layers downloaded.\n")
+	i.logger.Printf("Image digest: %s\n", image.ManifestDigest)
 
-	if !i.noTarball {
-		nameParts := strings.Split(i.imageName, "/")
-		if len(nameParts) != 2 {
-			return errors.New("Invalid image name")
-		}
-		outFile := filepath.Join(i.outDir, fmt.Sprintf("%s-%s.tgz", nameParts[1], i.imageTag))
+	if !i.opts.NoTarball {
+		outFile := filepath.Join(i.outDir, fmt.Sprintf("%s-%s.tgz", path.Base(repository), tarballSuffix(ref)))
 
 		i.logger.Printf("Writing %s...\n", outFile)
 
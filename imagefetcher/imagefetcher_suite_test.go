@@ -0,0 +1,13 @@
+package imagefetcher_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestImageFetcher(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ImageFetcher Suite")
+}
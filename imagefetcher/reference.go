@@ -0,0 +1,73 @@
+package imagefetcher
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// ParseReference accepts anything docker pull would, e.g. ubuntu,
+// mcr.microsoft.com/windows/nanoserver:ltsc2022,
+// myregistry.local:5000/team/windows/base:1809 or name@sha256:<digest>. The
+// tag is only used when the image does not include a tag or digest and the
+// default registry is only used when the image does not name a registry.
+func ParseReference(image, tag, defaultRegistry string) (name.Reference, error) {
+	opts := []name.Option{name.WeakValidation}
+	if tag != "" {
+		opts = append(opts, name.WithDefaultTag(tag))
+	}
+	if defaultRegistry != "" {
+		opts = append(opts, name.WithDefaultRegistry(defaultRegistry))
+	}
+
+	ref, err := name.ParseReference(image, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image reference %q: %s", image, err.Error())
+	}
+
+	return ref, nil
+}
+
+func isDigest(ref name.Reference) bool {
+	_, ok := ref.(name.Digest)
+	return ok
+}
+
+// tarballSuffix avoids the ':' of a digest, which is not valid in windows file names
+func tarballSuffix(ref name.Reference) string {
+	if d, ok := ref.(name.Digest); ok {
+		return strings.Replace(d.DigestStr(), ":", "-", 1)
+	}
+	return ref.Identifier()
+}
+
+// RegistryURL uses plain HTTP for localhost and any registry listed in
+// insecureRegistries, and HTTPS for everything else
+func RegistryURL(ref name.Reference, insecureRegistries []string) string {
+	registry := ref.Context().RegistryStr()
+	scheme := "https"
+	if isLoopback(registry) {
+		scheme = "http"
+	}
+	for _, insecure := range insecureRegistries {
+		if insecure == registry {
+			scheme = "http"
+		}
+	}
+	return fmt.Sprintf("%s://%s", scheme, registry)
+}
+
+func isLoopback(registry string) bool {
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
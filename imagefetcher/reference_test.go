@@ -0,0 +1,58 @@
+package imagefetcher_test
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/hydrator/imagefetcher"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseReference", func() {
+	DescribeTable("parses image references",
+		func(image, tag, defaultRegistry, expectedRegistry, expectedRepository, expectedTag string) {
+			ref, err := imagefetcher.ParseReference(image, tag, defaultRegistry)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref.Context().RegistryStr()).To(Equal(expectedRegistry))
+			Expect(ref.Context().RepositoryStr()).To(Equal(expectedRepository))
+			Expect(ref.Identifier()).To(Equal(expectedTag))
+		},
+		Entry("docker hub org/name", "cloudfoundry/windows2016fs", "1.0.0", "", "index.docker.io", "cloudfoundry/windows2016fs", "1.0.0"),
+		Entry("docker hub single part name", "ubuntu", "", "", "index.docker.io", "library/ubuntu", "latest"),
+		Entry("tag in the reference", "cloudfoundry/windows2016fs:2019", "latest", "", "index.docker.io", "cloudfoundry/windows2016fs", "2019"),
+		Entry("registry with port", "myregistry.local:5000/team/windows/base:1809", "latest", "", "myregistry.local:5000", "team/windows/base", "1809"),
+		Entry("mcr", "mcr.microsoft.com/windows/nanoserver:ltsc2022", "", "", "mcr.microsoft.com", "windows/nanoserver", "ltsc2022"),
+		Entry("default registry", "team/base", "1.0", "myregistry.local:5000", "myregistry.local:5000", "team/base", "1.0"),
+		Entry("default registry does not override the reference", "mcr.microsoft.com/windows/nanoserver", "ltsc2022", "myregistry.local:5000", "mcr.microsoft.com", "windows/nanoserver", "ltsc2022"),
+	)
+
+	It("parses digest references", func() {
+		ref, err := imagefetcher.ParseReference("mcr.microsoft.com/windows/nanoserver@sha256:"+strings.Repeat("a", 64), "latest", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ref.Context().RepositoryStr()).To(Equal("windows/nanoserver"))
+		Expect(ref.Identifier()).To(Equal("sha256:" + strings.Repeat("a", 64)))
+	})
+
+	It("returns an error for an invalid reference", func() {
+		_, err := imagefetcher.ParseReference("Not A Valid/Reference", "", "")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("RegistryURL", func() {
+	DescribeTable("picks the scheme for the registry",
+		func(image string, insecureRegistries []string, expectedURL string) {
+			ref, err := imagefetcher.ParseReference(image, "latest", "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(imagefetcher.RegistryURL(ref, insecureRegistries)).To(Equal(expectedURL))
+		},
+		Entry("docker hub", "cloudfoundry/windows2016fs", nil, "https://index.docker.io"),
+		Entry("localhost", "localhost:5000/team/base", nil, "http://localhost:5000"),
+		Entry("a loopback address", "127.0.0.1:5000/team/base", nil, "http://127.0.0.1:5000"),
+		Entry("a private address", "10.0.0.5:5000/team/base", nil, "https://10.0.0.5:5000"),
+		Entry("a .local registry", "myregistry.local:5000/team/base", nil, "https://myregistry.local:5000"),
+		Entry("a secure registry", "myregistry.example.com:5000/team/base", []string{"other.example.com:5000"}, "https://myregistry.example.com:5000"),
+		Entry("an insecure registry", "myregistry.example.com:5000/team/base", []string{"myregistry.example.com:5000"}, "http://myregistry.example.com:5000"),
+	)
+})
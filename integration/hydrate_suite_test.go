@@ -1,6 +1,7 @@
 package hydrate_test
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -54,7 +55,7 @@ var _ = SynchronizedBeforeSuite(func() []byte {
 			imageTag, present := os.LookupEnv("IMAGE_TAG")
 			Expect(present).To(BeTrue(), "IMAGE_TAG not set")
 
-			imagefetcher.New(logger, beforeSuiteOciImagePath, imageName, imageTag, "", true).Run()
+			imagefetcher.New(logger, beforeSuiteOciImagePath, imageName, imageTag, imagefetcher.Options{NoTarball: true}).Run(context.Background())
 			Expect(err).ToNot(HaveOccurred())
 		}
 
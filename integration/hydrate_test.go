@@ -161,6 +161,16 @@ var _ = Describe("Hydrate", func() {
 			})
 		})
 
+		Context("when -annotation sets a key that hydrate sets itself", func() {
+			It("should throw an error that names the annotation", func() {
+				hydrateArgs = []string{"add-layer", "--layer", "some-layer", "--ociImage", "some-oci-image", "--annotation", "hydrator.layerSource=other.tgz"}
+				hydrateSess := helpers.RunHydrate(hydrateArgs)
+				Eventually(hydrateSess).Should(gexec.Exit())
+				Expect(hydrateSess.ExitCode()).ToNot(Equal(0))
+				Expect(string(hydrateSess.Err.Contents())).To(ContainSubstring(`ERROR: Invalid annotation "hydrator.layerSource=other.tgz"`))
+			})
+		})
+
 		Context("when exactly -layer and -ociImage options are provided", func() {
 			var (
 				testOciImagePath string
@@ -449,6 +459,18 @@ var _ = Describe("Hydrate", func() {
 				})
 			})
 
+			Context("when the image reference includes the registry and tag", func() {
+				BeforeEach(func() {
+					hydrateArgs = []string{"download", "--outputDir", outputDir, "--image", fmt.Sprintf("docker.io/%s:%s", imageName, imageTag)}
+				})
+
+				It("downloads the tagged image", func() {
+					hydrateSess := helpers.RunHydrate(hydrateArgs)
+					Eventually(hydrateSess).Should(gexec.Exit(0))
+					Expect(filepath.Join(outputDir, imageTarballName)).To(BeAnExistingFile())
+				})
+			})
+
 			Context("when not provided an image", func() {
 				BeforeEach(func() {
 					hydrateArgs = []string{"download", "--outputDir", outputDir}
@@ -461,6 +483,32 @@ var _ = Describe("Hydrate", func() {
 					Expect(string(hydrateSess.Err.Contents())).To(ContainSubstring("ERROR: No image name provided"))
 				})
 			})
+
+			Context("when provided an invalid os version", func() {
+				BeforeEach(func() {
+					hydrateArgs = []string{"download", "--image", imageName, "--outputDir", outputDir, "--osVersion", "10.0.ltsc"}
+				})
+
+				It("errors", func() {
+					hydrateSess := helpers.RunHydrate(hydrateArgs)
+					Eventually(hydrateSess).Should(gexec.Exit())
+					Expect(hydrateSess.ExitCode()).ToNot(Equal(0))
+					Expect(string(hydrateSess.Err.Contents())).To(ContainSubstring(`ERROR: Invalid -osVersion "10.0.ltsc"`))
+				})
+			})
+
+			DescribeTable("an out of range retry option errors",
+				func(flag, value, message string) {
+					hydrateSess := helpers.RunHydrate([]string{"download", "--image", imageName, "--outputDir", outputDir, flag, value})
+					Eventually(hydrateSess).Should(gexec.Exit())
+					Expect(hydrateSess.ExitCode()).ToNot(Equal(0))
+					Expect(string(hydrateSess.Err.Contents())).To(ContainSubstring(message))
+				},
+				Entry("no attempts", "--retryAttempts", "0", "ERROR: Invalid -retryAttempts 0"),
+				Entry("a negative backoff", "--retryBackoff", "-1s", "ERROR: Invalid -retryBackoff -1s"),
+				Entry("a max backoff below the backoff", "--retryMaxBackoff", "500ms", "ERROR: Invalid -retryMaxBackoff 500ms"),
+				Entry("a jitter above 1", "--retryJitter", "1.5", "ERROR: Invalid -retryJitter 1.5"),
+			)
 		})
 
 		Context("when the output directory does not exist", func() {
@@ -6,15 +6,15 @@ import (
 
 	"code.cloudfoundry.org/hydrator/layermodifier"
 	digest "github.com/opencontainers/go-digest"
-	oci "github.com/opencontainers/image-spec/specs-go/v1"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type OCIDirectory struct {
-	AddBlobStub        func(srcPath string, blobDescriptor oci.Descriptor) error
+	AddBlobStub        func(string, v1.Descriptor) error
 	addBlobMutex       sync.RWMutex
 	addBlobArgsForCall []struct {
-		srcPath        string
-		blobDescriptor oci.Descriptor
+		arg1 string
+		arg2 v1.Descriptor
 	}
 	addBlobReturns struct {
 		result1 error
@@ -22,45 +22,61 @@ type OCIDirectory struct {
 	addBlobReturnsOnCall map[int]struct {
 		result1 error
 	}
-	RemoveTopBlobStub        func(sha256 string) error
-	removeTopBlobMutex       sync.RWMutex
-	removeTopBlobArgsForCall []struct {
-		sha256 string
-	}
-	removeTopBlobReturns struct {
-		result1 error
-	}
-	removeTopBlobReturnsOnCall map[int]struct {
-		result1 error
-	}
 	ClearMetadataStub        func() error
 	clearMetadataMutex       sync.RWMutex
-	clearMetadataArgsForCall []struct{}
-	clearMetadataReturns     struct {
+	clearMetadataArgsForCall []struct {
+	}
+	clearMetadataReturns struct {
 		result1 error
 	}
 	clearMetadataReturnsOnCall map[int]struct {
 		result1 error
 	}
-	ReadMetadataStub        func() (oci.Manifest, oci.Image, error)
+	ReadIndexAnnotationsStub        func() (map[string]string, error)
+	readIndexAnnotationsMutex       sync.RWMutex
+	readIndexAnnotationsArgsForCall []struct {
+	}
+	readIndexAnnotationsReturns struct {
+		result1 map[string]string
+		result2 error
+	}
+	readIndexAnnotationsReturnsOnCall map[int]struct {
+		result1 map[string]string
+		result2 error
+	}
+	ReadMetadataStub        func() (v1.Manifest, v1.Image, error)
 	readMetadataMutex       sync.RWMutex
-	readMetadataArgsForCall []struct{}
-	readMetadataReturns     struct {
-		result1 oci.Manifest
-		result2 oci.Image
+	readMetadataArgsForCall []struct {
+	}
+	readMetadataReturns struct {
+		result1 v1.Manifest
+		result2 v1.Image
 		result3 error
 	}
 	readMetadataReturnsOnCall map[int]struct {
-		result1 oci.Manifest
-		result2 oci.Image
+		result1 v1.Manifest
+		result2 v1.Image
 		result3 error
 	}
-	WriteMetadataStub        func(layers []oci.Descriptor, diffIds []digest.Digest, layerAdded bool) error
+	RemoveBlobIfUnreferencedStub        func(digest.Digest) (bool, error)
+	removeBlobIfUnreferencedMutex       sync.RWMutex
+	removeBlobIfUnreferencedArgsForCall []struct {
+		arg1 digest.Digest
+	}
+	removeBlobIfUnreferencedReturns struct {
+		result1 bool
+		result2 error
+	}
+	removeBlobIfUnreferencedReturnsOnCall map[int]struct {
+		result1 bool
+		result2 error
+	}
+	WriteMetadataStub        func(v1.Manifest, v1.Image, map[string]string) error
 	writeMetadataMutex       sync.RWMutex
 	writeMetadataArgsForCall []struct {
-		layers     []oci.Descriptor
-		diffIds    []digest.Digest
-		layerAdded bool
+		arg1 v1.Manifest
+		arg2 v1.Image
+		arg3 map[string]string
 	}
 	writeMetadataReturns struct {
 		result1 error
@@ -72,22 +88,24 @@ type OCIDirectory struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *OCIDirectory) AddBlob(srcPath string, blobDescriptor oci.Descriptor) error {
+func (fake *OCIDirectory) AddBlob(arg1 string, arg2 v1.Descriptor) error {
 	fake.addBlobMutex.Lock()
 	ret, specificReturn := fake.addBlobReturnsOnCall[len(fake.addBlobArgsForCall)]
 	fake.addBlobArgsForCall = append(fake.addBlobArgsForCall, struct {
-		srcPath        string
-		blobDescriptor oci.Descriptor
-	}{srcPath, blobDescriptor})
-	fake.recordInvocation("AddBlob", []interface{}{srcPath, blobDescriptor})
+		arg1 string
+		arg2 v1.Descriptor
+	}{arg1, arg2})
+	stub := fake.AddBlobStub
+	fakeReturns := fake.addBlobReturns
+	fake.recordInvocation("AddBlob", []interface{}{arg1, arg2})
 	fake.addBlobMutex.Unlock()
-	if fake.AddBlobStub != nil {
-		return fake.AddBlobStub(srcPath, blobDescriptor)
+	if stub != nil {
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	return fake.addBlobReturns.result1
+	return fakeReturns.result1
 }
 
 func (fake *OCIDirectory) AddBlobCallCount() int {
@@ -96,13 +114,22 @@ func (fake *OCIDirectory) AddBlobCallCount() int {
 	return len(fake.addBlobArgsForCall)
 }
 
-func (fake *OCIDirectory) AddBlobArgsForCall(i int) (string, oci.Descriptor) {
+func (fake *OCIDirectory) AddBlobCalls(stub func(string, v1.Descriptor) error) {
+	fake.addBlobMutex.Lock()
+	defer fake.addBlobMutex.Unlock()
+	fake.AddBlobStub = stub
+}
+
+func (fake *OCIDirectory) AddBlobArgsForCall(i int) (string, v1.Descriptor) {
 	fake.addBlobMutex.RLock()
 	defer fake.addBlobMutex.RUnlock()
-	return fake.addBlobArgsForCall[i].srcPath, fake.addBlobArgsForCall[i].blobDescriptor
+	argsForCall := fake.addBlobArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *OCIDirectory) AddBlobReturns(result1 error) {
+	fake.addBlobMutex.Lock()
+	defer fake.addBlobMutex.Unlock()
 	fake.AddBlobStub = nil
 	fake.addBlobReturns = struct {
 		result1 error
@@ -110,6 +137,8 @@ func (fake *OCIDirectory) AddBlobReturns(result1 error) {
 }
 
 func (fake *OCIDirectory) AddBlobReturnsOnCall(i int, result1 error) {
+	fake.addBlobMutex.Lock()
+	defer fake.addBlobMutex.Unlock()
 	fake.AddBlobStub = nil
 	if fake.addBlobReturnsOnCall == nil {
 		fake.addBlobReturnsOnCall = make(map[int]struct {
@@ -121,67 +150,22 @@ func (fake *OCIDirectory) AddBlobReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *OCIDirectory) RemoveTopBlob(sha256 string) error {
-	fake.removeTopBlobMutex.Lock()
-	ret, specificReturn := fake.removeTopBlobReturnsOnCall[len(fake.removeTopBlobArgsForCall)]
-	fake.removeTopBlobArgsForCall = append(fake.removeTopBlobArgsForCall, struct {
-		sha256 string
-	}{sha256})
-	fake.recordInvocation("RemoveTopBlob", []interface{}{sha256})
-	fake.removeTopBlobMutex.Unlock()
-	if fake.RemoveTopBlobStub != nil {
-		return fake.RemoveTopBlobStub(sha256)
-	}
-	if specificReturn {
-		return ret.result1
-	}
-	return fake.removeTopBlobReturns.result1
-}
-
-func (fake *OCIDirectory) RemoveTopBlobCallCount() int {
-	fake.removeTopBlobMutex.RLock()
-	defer fake.removeTopBlobMutex.RUnlock()
-	return len(fake.removeTopBlobArgsForCall)
-}
-
-func (fake *OCIDirectory) RemoveTopBlobArgsForCall(i int) string {
-	fake.removeTopBlobMutex.RLock()
-	defer fake.removeTopBlobMutex.RUnlock()
-	return fake.removeTopBlobArgsForCall[i].sha256
-}
-
-func (fake *OCIDirectory) RemoveTopBlobReturns(result1 error) {
-	fake.RemoveTopBlobStub = nil
-	fake.removeTopBlobReturns = struct {
-		result1 error
-	}{result1}
-}
-
-func (fake *OCIDirectory) RemoveTopBlobReturnsOnCall(i int, result1 error) {
-	fake.RemoveTopBlobStub = nil
-	if fake.removeTopBlobReturnsOnCall == nil {
-		fake.removeTopBlobReturnsOnCall = make(map[int]struct {
-			result1 error
-		})
-	}
-	fake.removeTopBlobReturnsOnCall[i] = struct {
-		result1 error
-	}{result1}
-}
-
 func (fake *OCIDirectory) ClearMetadata() error {
 	fake.clearMetadataMutex.Lock()
 	ret, specificReturn := fake.clearMetadataReturnsOnCall[len(fake.clearMetadataArgsForCall)]
-	fake.clearMetadataArgsForCall = append(fake.clearMetadataArgsForCall, struct{}{})
+	fake.clearMetadataArgsForCall = append(fake.clearMetadataArgsForCall, struct {
+	}{})
+	stub := fake.ClearMetadataStub
+	fakeReturns := fake.clearMetadataReturns
 	fake.recordInvocation("ClearMetadata", []interface{}{})
 	fake.clearMetadataMutex.Unlock()
-	if fake.ClearMetadataStub != nil {
-		return fake.ClearMetadataStub()
+	if stub != nil {
+		return stub()
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	return fake.clearMetadataReturns.result1
+	return fakeReturns.result1
 }
 
 func (fake *OCIDirectory) ClearMetadataCallCount() int {
@@ -190,7 +174,15 @@ func (fake *OCIDirectory) ClearMetadataCallCount() int {
 	return len(fake.clearMetadataArgsForCall)
 }
 
+func (fake *OCIDirectory) ClearMetadataCalls(stub func() error) {
+	fake.clearMetadataMutex.Lock()
+	defer fake.clearMetadataMutex.Unlock()
+	fake.ClearMetadataStub = stub
+}
+
 func (fake *OCIDirectory) ClearMetadataReturns(result1 error) {
+	fake.clearMetadataMutex.Lock()
+	defer fake.clearMetadataMutex.Unlock()
 	fake.ClearMetadataStub = nil
 	fake.clearMetadataReturns = struct {
 		result1 error
@@ -198,6 +190,8 @@ func (fake *OCIDirectory) ClearMetadataReturns(result1 error) {
 }
 
 func (fake *OCIDirectory) ClearMetadataReturnsOnCall(i int, result1 error) {
+	fake.clearMetadataMutex.Lock()
+	defer fake.clearMetadataMutex.Unlock()
 	fake.ClearMetadataStub = nil
 	if fake.clearMetadataReturnsOnCall == nil {
 		fake.clearMetadataReturnsOnCall = make(map[int]struct {
@@ -209,19 +203,78 @@ func (fake *OCIDirectory) ClearMetadataReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
-func (fake *OCIDirectory) ReadMetadata() (oci.Manifest, oci.Image, error) {
+func (fake *OCIDirectory) ReadIndexAnnotations() (map[string]string, error) {
+	fake.readIndexAnnotationsMutex.Lock()
+	ret, specificReturn := fake.readIndexAnnotationsReturnsOnCall[len(fake.readIndexAnnotationsArgsForCall)]
+	fake.readIndexAnnotationsArgsForCall = append(fake.readIndexAnnotationsArgsForCall, struct {
+	}{})
+	stub := fake.ReadIndexAnnotationsStub
+	fakeReturns := fake.readIndexAnnotationsReturns
+	fake.recordInvocation("ReadIndexAnnotations", []interface{}{})
+	fake.readIndexAnnotationsMutex.Unlock()
+	if stub != nil {
+		return stub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *OCIDirectory) ReadIndexAnnotationsCallCount() int {
+	fake.readIndexAnnotationsMutex.RLock()
+	defer fake.readIndexAnnotationsMutex.RUnlock()
+	return len(fake.readIndexAnnotationsArgsForCall)
+}
+
+func (fake *OCIDirectory) ReadIndexAnnotationsCalls(stub func() (map[string]string, error)) {
+	fake.readIndexAnnotationsMutex.Lock()
+	defer fake.readIndexAnnotationsMutex.Unlock()
+	fake.ReadIndexAnnotationsStub = stub
+}
+
+func (fake *OCIDirectory) ReadIndexAnnotationsReturns(result1 map[string]string, result2 error) {
+	fake.readIndexAnnotationsMutex.Lock()
+	defer fake.readIndexAnnotationsMutex.Unlock()
+	fake.ReadIndexAnnotationsStub = nil
+	fake.readIndexAnnotationsReturns = struct {
+		result1 map[string]string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *OCIDirectory) ReadIndexAnnotationsReturnsOnCall(i int, result1 map[string]string, result2 error) {
+	fake.readIndexAnnotationsMutex.Lock()
+	defer fake.readIndexAnnotationsMutex.Unlock()
+	fake.ReadIndexAnnotationsStub = nil
+	if fake.readIndexAnnotationsReturnsOnCall == nil {
+		fake.readIndexAnnotationsReturnsOnCall = make(map[int]struct {
+			result1 map[string]string
+			result2 error
+		})
+	}
+	fake.readIndexAnnotationsReturnsOnCall[i] = struct {
+		result1 map[string]string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *OCIDirectory) ReadMetadata() (v1.Manifest, v1.Image, error) {
 	fake.readMetadataMutex.Lock()
 	ret, specificReturn := fake.readMetadataReturnsOnCall[len(fake.readMetadataArgsForCall)]
-	fake.readMetadataArgsForCall = append(fake.readMetadataArgsForCall, struct{}{})
+	fake.readMetadataArgsForCall = append(fake.readMetadataArgsForCall, struct {
+	}{})
+	stub := fake.ReadMetadataStub
+	fakeReturns := fake.readMetadataReturns
 	fake.recordInvocation("ReadMetadata", []interface{}{})
 	fake.readMetadataMutex.Unlock()
-	if fake.ReadMetadataStub != nil {
-		return fake.ReadMetadataStub()
+	if stub != nil {
+		return stub()
 	}
 	if specificReturn {
 		return ret.result1, ret.result2, ret.result3
 	}
-	return fake.readMetadataReturns.result1, fake.readMetadataReturns.result2, fake.readMetadataReturns.result3
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
 }
 
 func (fake *OCIDirectory) ReadMetadataCallCount() int {
@@ -230,58 +283,124 @@ func (fake *OCIDirectory) ReadMetadataCallCount() int {
 	return len(fake.readMetadataArgsForCall)
 }
 
-func (fake *OCIDirectory) ReadMetadataReturns(result1 oci.Manifest, result2 oci.Image, result3 error) {
+func (fake *OCIDirectory) ReadMetadataCalls(stub func() (v1.Manifest, v1.Image, error)) {
+	fake.readMetadataMutex.Lock()
+	defer fake.readMetadataMutex.Unlock()
+	fake.ReadMetadataStub = stub
+}
+
+func (fake *OCIDirectory) ReadMetadataReturns(result1 v1.Manifest, result2 v1.Image, result3 error) {
+	fake.readMetadataMutex.Lock()
+	defer fake.readMetadataMutex.Unlock()
 	fake.ReadMetadataStub = nil
 	fake.readMetadataReturns = struct {
-		result1 oci.Manifest
-		result2 oci.Image
+		result1 v1.Manifest
+		result2 v1.Image
 		result3 error
 	}{result1, result2, result3}
 }
 
-func (fake *OCIDirectory) ReadMetadataReturnsOnCall(i int, result1 oci.Manifest, result2 oci.Image, result3 error) {
+func (fake *OCIDirectory) ReadMetadataReturnsOnCall(i int, result1 v1.Manifest, result2 v1.Image, result3 error) {
+	fake.readMetadataMutex.Lock()
+	defer fake.readMetadataMutex.Unlock()
 	fake.ReadMetadataStub = nil
 	if fake.readMetadataReturnsOnCall == nil {
 		fake.readMetadataReturnsOnCall = make(map[int]struct {
-			result1 oci.Manifest
-			result2 oci.Image
+			result1 v1.Manifest
+			result2 v1.Image
 			result3 error
 		})
 	}
 	fake.readMetadataReturnsOnCall[i] = struct {
-		result1 oci.Manifest
-		result2 oci.Image
+		result1 v1.Manifest
+		result2 v1.Image
 		result3 error
 	}{result1, result2, result3}
 }
 
-func (fake *OCIDirectory) WriteMetadata(layers []oci.Descriptor, diffIds []digest.Digest, layerAdded bool) error {
-	var layersCopy []oci.Descriptor
-	if layers != nil {
-		layersCopy = make([]oci.Descriptor, len(layers))
-		copy(layersCopy, layers)
+func (fake *OCIDirectory) RemoveBlobIfUnreferenced(arg1 digest.Digest) (bool, error) {
+	fake.removeBlobIfUnreferencedMutex.Lock()
+	ret, specificReturn := fake.removeBlobIfUnreferencedReturnsOnCall[len(fake.removeBlobIfUnreferencedArgsForCall)]
+	fake.removeBlobIfUnreferencedArgsForCall = append(fake.removeBlobIfUnreferencedArgsForCall, struct {
+		arg1 digest.Digest
+	}{arg1})
+	stub := fake.RemoveBlobIfUnreferencedStub
+	fakeReturns := fake.removeBlobIfUnreferencedReturns
+	fake.recordInvocation("RemoveBlobIfUnreferenced", []interface{}{arg1})
+	fake.removeBlobIfUnreferencedMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
 	}
-	var diffIdsCopy []digest.Digest
-	if diffIds != nil {
-		diffIdsCopy = make([]digest.Digest, len(diffIds))
-		copy(diffIdsCopy, diffIds)
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *OCIDirectory) RemoveBlobIfUnreferencedCallCount() int {
+	fake.removeBlobIfUnreferencedMutex.RLock()
+	defer fake.removeBlobIfUnreferencedMutex.RUnlock()
+	return len(fake.removeBlobIfUnreferencedArgsForCall)
+}
+
+func (fake *OCIDirectory) RemoveBlobIfUnreferencedCalls(stub func(digest.Digest) (bool, error)) {
+	fake.removeBlobIfUnreferencedMutex.Lock()
+	defer fake.removeBlobIfUnreferencedMutex.Unlock()
+	fake.RemoveBlobIfUnreferencedStub = stub
+}
+
+func (fake *OCIDirectory) RemoveBlobIfUnreferencedArgsForCall(i int) digest.Digest {
+	fake.removeBlobIfUnreferencedMutex.RLock()
+	defer fake.removeBlobIfUnreferencedMutex.RUnlock()
+	argsForCall := fake.removeBlobIfUnreferencedArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *OCIDirectory) RemoveBlobIfUnreferencedReturns(result1 bool, result2 error) {
+	fake.removeBlobIfUnreferencedMutex.Lock()
+	defer fake.removeBlobIfUnreferencedMutex.Unlock()
+	fake.RemoveBlobIfUnreferencedStub = nil
+	fake.removeBlobIfUnreferencedReturns = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *OCIDirectory) RemoveBlobIfUnreferencedReturnsOnCall(i int, result1 bool, result2 error) {
+	fake.removeBlobIfUnreferencedMutex.Lock()
+	defer fake.removeBlobIfUnreferencedMutex.Unlock()
+	fake.RemoveBlobIfUnreferencedStub = nil
+	if fake.removeBlobIfUnreferencedReturnsOnCall == nil {
+		fake.removeBlobIfUnreferencedReturnsOnCall = make(map[int]struct {
+			result1 bool
+			result2 error
+		})
 	}
+	fake.removeBlobIfUnreferencedReturnsOnCall[i] = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *OCIDirectory) WriteMetadata(arg1 v1.Manifest, arg2 v1.Image, arg3 map[string]string) error {
 	fake.writeMetadataMutex.Lock()
 	ret, specificReturn := fake.writeMetadataReturnsOnCall[len(fake.writeMetadataArgsForCall)]
 	fake.writeMetadataArgsForCall = append(fake.writeMetadataArgsForCall, struct {
-		layers     []oci.Descriptor
-		diffIds    []digest.Digest
-		layerAdded bool
-	}{layersCopy, diffIdsCopy, layerAdded})
-	fake.recordInvocation("WriteMetadata", []interface{}{layersCopy, diffIdsCopy, layerAdded})
+		arg1 v1.Manifest
+		arg2 v1.Image
+		arg3 map[string]string
+	}{arg1, arg2, arg3})
+	stub := fake.WriteMetadataStub
+	fakeReturns := fake.writeMetadataReturns
+	fake.recordInvocation("WriteMetadata", []interface{}{arg1, arg2, arg3})
 	fake.writeMetadataMutex.Unlock()
-	if fake.WriteMetadataStub != nil {
-		return fake.WriteMetadataStub(layers, diffIds, layerAdded)
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	return fake.writeMetadataReturns.result1
+	return fakeReturns.result1
 }
 
 func (fake *OCIDirectory) WriteMetadataCallCount() int {
@@ -290,14 +409,22 @@ func (fake *OCIDirectory) WriteMetadataCallCount() int {
 	return len(fake.writeMetadataArgsForCall)
 }
 
-func (fake *OCIDirectory) WriteMetadataArgsForCall(i int) ([]oci.Descriptor, []digest.Digest, bool) {
+func (fake *OCIDirectory) WriteMetadataCalls(stub func(v1.Manifest, v1.Image, map[string]string) error) {
+	fake.writeMetadataMutex.Lock()
+	defer fake.writeMetadataMutex.Unlock()
+	fake.WriteMetadataStub = stub
+}
+
+func (fake *OCIDirectory) WriteMetadataArgsForCall(i int) (v1.Manifest, v1.Image, map[string]string) {
 	fake.writeMetadataMutex.RLock()
 	defer fake.writeMetadataMutex.RUnlock()
-	return fake.writeMetadataArgsForCall[i].layers, fake.writeMetadataArgsForCall[i].diffIds, fake.writeMetadataArgsForCall[i].layerAdded
-
+	argsForCall := fake.writeMetadataArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
 func (fake *OCIDirectory) WriteMetadataReturns(result1 error) {
+	fake.writeMetadataMutex.Lock()
+	defer fake.writeMetadataMutex.Unlock()
 	fake.WriteMetadataStub = nil
 	fake.writeMetadataReturns = struct {
 		result1 error
@@ -305,6 +432,8 @@ func (fake *OCIDirectory) WriteMetadataReturns(result1 error) {
 }
 
 func (fake *OCIDirectory) WriteMetadataReturnsOnCall(i int, result1 error) {
+	fake.writeMetadataMutex.Lock()
+	defer fake.writeMetadataMutex.Unlock()
 	fake.WriteMetadataStub = nil
 	if fake.writeMetadataReturnsOnCall == nil {
 		fake.writeMetadataReturnsOnCall = make(map[int]struct {
@@ -319,16 +448,6 @@ func (fake *OCIDirectory) WriteMetadataReturnsOnCall(i int, result1 error) {
 func (fake *OCIDirectory) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
-	fake.addBlobMutex.RLock()
-	defer fake.addBlobMutex.RUnlock()
-	fake.removeTopBlobMutex.RLock()
-	defer fake.removeTopBlobMutex.RUnlock()
-	fake.clearMetadataMutex.RLock()
-	defer fake.clearMetadataMutex.RUnlock()
-	fake.readMetadataMutex.RLock()
-	defer fake.readMetadataMutex.RUnlock()
-	fake.writeMetadataMutex.RLock()
-	defer fake.writeMetadataMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
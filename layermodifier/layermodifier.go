@@ -5,7 +5,8 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strings"
+	"path/filepath"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	digest "github.com/opencontainers/go-digest"
@@ -15,12 +16,27 @@ import (
 //go:generate counterfeiter -o fakes/oci_directory.go --fake-name OCIDirectory . OCIDirectory
 type OCIDirectory interface {
 	AddBlob(srcPath string, blobDescriptor oci.Descriptor) error
-	RemoveTopBlob(sha256 string) error
+	RemoveBlobIfUnreferenced(blob digest.Digest) (bool, error)
 	ClearMetadata() error
 	ReadMetadata() (oci.Manifest, oci.Image, error)
-	WriteMetadata(layers []oci.Descriptor, diffIds []digest.Digest, layerAdded bool) error
+	ReadIndexAnnotations() (map[string]string, error)
+	WriteMetadata(manifest oci.Manifest, config oci.Image, indexAnnotations map[string]string) error
 }
 
+/*
+ * hydrator.layerAdded is set on each layer added by hydrator, with the name of
+ * the file it came from and when it was added, and on the manifest while its
+ * top layer is one of them
+ */
+const (
+	layerAddedAnnotation   = "hydrator.layerAdded"
+	layerSourceAnnotation  = "hydrator.layerSource"
+	layerCreatedAnnotation = "hydrator.layerCreated"
+
+	dockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerLayer    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
 type LayerModifier struct {
 	ociDirectory OCIDirectory
 }
@@ -31,7 +47,9 @@ func New(ociDirectory OCIDirectory) *LayerModifier {
 	}
 }
 
-func (l *LayerModifier) AddLayer(layerTgzPath string) error {
+// AddLayer adds the layer on top of the image, with the given annotations on
+// its descriptor as well as the ones recording that hydrator added it
+func (l *LayerModifier) AddLayer(layerTgzPath string, annotations map[string]string) error {
 	descriptor, diffId, err := l.getLayerDescriptor(layerTgzPath)
 	if err != nil {
 		return err
@@ -46,41 +64,236 @@ func (l *LayerModifier) AddLayer(layerTgzPath string) error {
 		return err
 	}
 
+	indexAnnotations, err := l.ociDirectory.ReadIndexAnnotations()
+	if err != nil {
+		return err
+	}
+
 	if err := l.ociDirectory.ClearMetadata(); err != nil {
 		return err
 	}
 
-	newLayers := append(manifest.Layers, descriptor)
-	newDiffIDs := append(config.RootFS.DiffIDs, diffId)
-	layerAdded := true
-	return l.ociDirectory.WriteMetadata(newLayers, newDiffIDs, layerAdded)
+	/* a docker manifest, kept from the registry by download -preserveDigest, only lists docker layers */
+	if manifest.MediaType == dockerManifest {
+		descriptor.MediaType = dockerLayer
+	}
+
+	now := time.Now().UTC()
+	source := filepath.Base(layerTgzPath)
+
+	descriptor.Annotations = map[string]string{}
+	for k, v := range annotations {
+		descriptor.Annotations[k] = v
+	}
+	descriptor.Annotations[layerAddedAnnotation] = "true"
+	descriptor.Annotations[layerSourceAnnotation] = source
+	descriptor.Annotations[layerCreatedAnnotation] = now.Format(time.RFC3339)
+
+	/* a layer added before layers had their own annotations keeps being recognised once it is no longer on top */
+	if top := len(manifest.Layers) - 1; top >= 0 && isHydratorLayer(manifest, top) {
+		if manifest.Layers[top].Annotations == nil {
+			manifest.Layers[top].Annotations = map[string]string{}
+		}
+		manifest.Layers[top].Annotations[layerAddedAnnotation] = "true"
+	}
+
+	manifest.Layers = append(manifest.Layers, descriptor)
+	markTopLayer(&manifest)
+
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffId)
+	/* history without an entry per layer would be misleading, so it is only extended if the image has one */
+	if len(config.History) > 0 {
+		config.History = append(config.History, oci.History{Created: &now, CreatedBy: "hydrate add-layer " + source})
+	}
+
+	return l.ociDirectory.WriteMetadata(manifest, config, indexAnnotations)
 }
 
+// RemoveHydratorLayer removes the top layer if it was added by hydrator, and
+// otherwise leaves the image unchanged without an error
 func (l *LayerModifier) RemoveHydratorLayer() error {
 	manifest, config, err := l.ociDirectory.ReadMetadata()
 	if err != nil {
 		return err
 	}
 
-	if _, ok := manifest.Annotations["hydrator.layerAdded"]; !ok {
+	top := len(manifest.Layers) - 1
+	if top < 0 || !isHydratorLayer(manifest, top) {
 		return nil
 	}
+	return l.removeLayers(manifest, config, []int{top})
+}
+
+// RemoveHydratorLayers removes count layers from the top of the image, or all
+// of the layers at the top that hydrator added if count is 0. It returns an
+// error without changing the image if any of the count layers was not added
+// by hydrator.
+func (l *LayerModifier) RemoveHydratorLayers(count int) error {
+	manifest, config, err := l.ociDirectory.ReadMetadata()
+	if err != nil {
+		return err
+	}
+
+	added := 0
+	for added < len(manifest.Layers) && isHydratorLayer(manifest, len(manifest.Layers)-1-added) {
+		added++
+	}
+
+	if count == 0 {
+		count = added
+		if count == 0 {
+			return nil
+		}
+	}
+
+	if count > added {
+		i := len(manifest.Layers) - 1 - added
+		if i < 0 {
+			return fmt.Errorf("cannot remove %d layers: the image has %d", count, len(manifest.Layers))
+		}
+		return fmt.Errorf("cannot remove %d layers: layer %d (%s) was not added by hydrator", count, i, manifest.Layers[i].Digest)
+	}
+
+	indexes := []int{}
+	for n := 0; n < count; n++ {
+		indexes = append(indexes, len(manifest.Layers)-1-n)
+	}
+	return l.removeLayers(manifest, config, indexes)
+}
+
+// RemoveLayer removes the layer at index, counting from 0 at the bottom of the
+// image. Unless force is set, the layer must have been added by hydrator.
+func (l *LayerModifier) RemoveLayer(index int, force bool) error {
+	manifest, config, err := l.ociDirectory.ReadMetadata()
+	if err != nil {
+		return err
+	}
+
+	if index < 0 || index >= len(manifest.Layers) {
+		return fmt.Errorf("invalid layer index %d: the image has %d layers", index, len(manifest.Layers))
+	}
+
+	if err := checkRemovable(manifest, index, force); err != nil {
+		return err
+	}
+	return l.removeLayers(manifest, config, []int{index})
+}
+
+// RemoveLayerByDigest removes the layer with the given digest. Unless force is
+// set, the layer must have been added by hydrator.
+func (l *LayerModifier) RemoveLayerByDigest(layerDigest digest.Digest, force bool) error {
+	manifest, config, err := l.ociDirectory.ReadMetadata()
+	if err != nil {
+		return err
+	}
+
+	index := -1
+	for i, layer := range manifest.Layers {
+		if layer.Digest != layerDigest {
+			continue
+		}
+		if index != -1 {
+			return fmt.Errorf("layer %s appears more than once in the image, remove it by index", layerDigest)
+		}
+		index = i
+	}
+
+	if index == -1 {
+		return fmt.Errorf("image does not contain layer %s", layerDigest)
+	}
+
+	if err := checkRemovable(manifest, index, force); err != nil {
+		return err
+	}
+	return l.removeLayers(manifest, config, []int{index})
+}
+
+func checkRemovable(manifest oci.Manifest, index int, force bool) error {
+	if !force && !isHydratorLayer(manifest, index) {
+		return fmt.Errorf("layer %d (%s) was not added by hydrator, use -force to remove it", index, manifest.Layers[index].Digest)
+	}
+	return nil
+}
+
+// removeLayers removes the layers at indexes, which must be in descending
+// order, from the manifest, diffIDs and history. Their blobs are removed once
+// the new metadata is written, unless a manifest still refers to them.
+func (l *LayerModifier) removeLayers(manifest oci.Manifest, config oci.Image, indexes []int) error {
+	indexAnnotations, err := l.ociDirectory.ReadIndexAnnotations()
+	if err != nil {
+		return err
+	}
 
 	if err := l.ociDirectory.ClearMetadata(); err != nil {
 		return err
 	}
 
-	lastLayer := manifest.Layers[len(manifest.Layers)-1]
-	layerDigest := (strings.Split(string(lastLayer.Digest), ":"))[1] //lastLayer.Digest = "sha256:LAYER_SHA"
-	if err := l.ociDirectory.RemoveTopBlob(layerDigest); err != nil {
+	removed := []digest.Digest{}
+	for _, i := range indexes {
+		removed = append(removed, manifest.Layers[i].Digest)
+
+		config.History = removeLayerHistory(config.History, len(manifest.Layers)-1-i)
+		manifest.Layers = append(manifest.Layers[:i:i], manifest.Layers[i+1:]...)
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs[:i:i], config.RootFS.DiffIDs[i+1:]...)
+	}
+
+	markTopLayer(&manifest)
+
+	if err := l.ociDirectory.WriteMetadata(manifest, config, indexAnnotations); err != nil {
 		return err
 	}
 
-	newLayers := manifest.Layers[:len(manifest.Layers)-1]
-	newDiffIDs := config.RootFS.DiffIDs[:len(config.RootFS.DiffIDs)-1]
-	layerAdded := false
+	for _, d := range removed {
+		if _, err := l.ociDirectory.RemoveBlobIfUnreferenced(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isHydratorLayer also recognises the top layer of images written before
+// layers had their own annotations, which only have the manifest annotation
+func isHydratorLayer(manifest oci.Manifest, i int) bool {
+	if _, ok := manifest.Layers[i].Annotations[layerAddedAnnotation]; ok {
+		return true
+	}
+
+	_, ok := manifest.Annotations[layerAddedAnnotation]
+	return ok && i == len(manifest.Layers)-1
+}
+
+// markTopLayer sets the manifest annotation if the top layer was added by
+// hydrator, and removes it otherwise
+func markTopLayer(manifest *oci.Manifest) {
+	delete(manifest.Annotations, layerAddedAnnotation)
+	if len(manifest.Layers) == 0 {
+		return
+	}
+
+	if _, ok := manifest.Layers[len(manifest.Layers)-1].Annotations[layerAddedAnnotation]; !ok {
+		return
+	}
 
-	return l.ociDirectory.WriteMetadata(newLayers, newDiffIDs, layerAdded)
+	if manifest.Annotations == nil {
+		manifest.Annotations = map[string]string{}
+	}
+	manifest.Annotations[layerAddedAnnotation] = "true"
+}
+
+// removeLayerHistory removes the entry for the layer that is fromTop layers
+// below the top one. Empty layer entries, such as ENV, have no layer, so they
+// are skipped when counting.
+func removeLayerHistory(history []oci.History, fromTop int) []oci.History {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].EmptyLayer {
+			continue
+		}
+		if fromTop == 0 {
+			return append(history[:i:i], history[i+1:]...)
+		}
+		fromTop--
+	}
+	return history
 }
 
 func (l *LayerModifier) getLayerDescriptor(layerTgzPath string) (oci.Descriptor, digest.Digest, error) {
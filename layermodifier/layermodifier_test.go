@@ -2,9 +2,11 @@ package layermodifier_test
 
 import (
 	"compress/gzip"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -15,6 +17,8 @@ import (
 
 	"code.cloudfoundry.org/hydrator/layermodifier"
 	fakes "code.cloudfoundry.org/hydrator/layermodifier/fakes"
+	directory "code.cloudfoundry.org/hydrator/oci-directory"
+	specs "github.com/opencontainers/image-spec/specs-go"
 )
 
 var _ = Describe("LayerModifier", func() {
@@ -23,6 +27,8 @@ var _ = Describe("LayerModifier", func() {
 		fakeOCIDirectory *fakes.OCIDirectory
 		manifest         oci.Manifest
 		ociImageConfig   oci.Image
+		indexAnnotations map[string]string
+		created          time.Time
 	)
 
 	BeforeEach(func() {
@@ -32,20 +38,35 @@ var _ = Describe("LayerModifier", func() {
 				{Digest: "sha256:layer2", Size: 6789, MediaType: oci.MediaTypeImageLayerGzip},
 			},
 			Annotations: map[string]string{
-				"hydrator.layerAdded": "true",
+				"hydrator.layerAdded":  "true",
+				"hydrator.sourceImage": "some-image",
 			},
 		}
+		created = time.Date(2020, 11, 10, 12, 0, 0, 0, time.UTC)
 		ociImageConfig = oci.Image{
+			Created:  &created,
+			Platform: oci.Platform{OS: "linux", Architecture: "arm64"},
+			Config: oci.ImageConfig{
+				Env: []string{"FOO=bar"},
+				Cmd: []string{"some-cmd"},
+			},
 			RootFS: oci.RootFS{
 				DiffIDs: []digest.Digest{
 					digest.NewDigestFromEncoded(digest.SHA256, "abcd"),
 					digest.NewDigestFromEncoded(digest.SHA256, "ef12"),
 				},
 			},
+			History: []oci.History{
+				{Created: &created, CreatedBy: "some-base-layer"},
+				{Created: &created, CreatedBy: "ENV FOO=bar", EmptyLayer: true},
+				{Created: &created, CreatedBy: "some-other-layer"},
+			},
 		}
+		indexAnnotations = map[string]string{"some-index-key": "some-index-value"}
 
 		fakeOCIDirectory = &fakes.OCIDirectory{}
 		fakeOCIDirectory.ReadMetadataReturns(manifest, ociImageConfig, nil)
+		fakeOCIDirectory.ReadIndexAnnotationsReturns(indexAnnotations, nil)
 		layerModifier = layermodifier.New(fakeOCIDirectory)
 	})
 
@@ -87,7 +108,7 @@ var _ = Describe("LayerModifier", func() {
 			})
 
 			It("copies in the layer, and updates the OCI image metadata with the new layer", func() {
-				Expect(layerModifier.AddLayer(layerTgzPath)).To(Succeed())
+				Expect(layerModifier.AddLayer(layerTgzPath, nil)).To(Succeed())
 
 				expectedDescriptor := oci.Descriptor{
 					Digest:    digest.NewDigestFromEncoded(digest.SHA256, gzippedSHA256),
@@ -106,11 +127,12 @@ var _ = Describe("LayerModifier", func() {
 				Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(1))
 
 				Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(1))
-				newLayers, newDiffIDs, layerAdded := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+				newManifest, newConfig, newIndexAnnotations := fakeOCIDirectory.WriteMetadataArgsForCall(0)
 
+				/* layer2 was marked by the manifest annotation alone, and now has its own */
 				expectedLayers := []oci.Descriptor{
 					{Digest: "sha256:layer1", Size: 1234, MediaType: oci.MediaTypeImageLayerGzip},
-					{Digest: "sha256:layer2", Size: 6789, MediaType: oci.MediaTypeImageLayerGzip},
+					{Digest: "sha256:layer2", Size: 6789, MediaType: oci.MediaTypeImageLayerGzip, Annotations: map[string]string{"hydrator.layerAdded": "true"}},
 					expectedDescriptor,
 				}
 
@@ -120,9 +142,92 @@ var _ = Describe("LayerModifier", func() {
 					expectedDiffID,
 				}
 
-				Expect(newLayers).To(Equal(expectedLayers))
-				Expect(newDiffIDs).To(Equal(expectedDiffIDs))
-				Expect(layerAdded).To(BeTrue())
+				Expect(newManifest.Layers).To(HaveLen(3))
+				Expect(newManifest.Layers[:2]).To(Equal(expectedLayers[:2]))
+				Expect(newManifest.Layers[2].Digest).To(Equal(expectedDescriptor.Digest))
+				Expect(newManifest.Layers[2].MediaType).To(Equal(expectedDescriptor.MediaType))
+				Expect(newManifest.Layers[2].Size).To(Equal(expectedDescriptor.Size))
+				Expect(newConfig.RootFS.DiffIDs).To(Equal(expectedDiffIDs))
+				Expect(newConfig.Platform).To(Equal(oci.Platform{OS: "linux", Architecture: "arm64"}))
+				Expect(newManifest.Annotations).To(HaveKeyWithValue("hydrator.layerAdded", "true"))
+				Expect(newIndexAnnotations).To(Equal(indexAnnotations))
+			})
+
+			It("keeps the rest of the manifest and config, and adds a history entry", func() {
+				Expect(layerModifier.AddLayer(layerTgzPath, nil)).To(Succeed())
+
+				newManifest, newConfig, newIndexAnnotations := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+				Expect(newManifest.Annotations).To(Equal(map[string]string{
+					"hydrator.layerAdded":  "true",
+					"hydrator.sourceImage": "some-image",
+				}))
+				Expect(newIndexAnnotations).To(Equal(indexAnnotations))
+
+				Expect(newConfig.Created).To(Equal(&created))
+				Expect(newConfig.Config).To(Equal(ociImageConfig.Config))
+
+				Expect(newConfig.History).To(HaveLen(4))
+				Expect(newConfig.History[:3]).To(Equal(ociImageConfig.History))
+				Expect(newConfig.History[3].CreatedBy).To(Equal("hydrate add-layer my-new-layer.tgz"))
+				Expect(newConfig.History[3].EmptyLayer).To(BeFalse())
+				Expect(*newConfig.History[3].Created).To(BeTemporally("~", time.Now(), time.Minute))
+			})
+
+			It("records the provenance of the layer on its descriptor", func() {
+				Expect(layerModifier.AddLayer(layerTgzPath, map[string]string{"some-key": "some-value", "hydrator.layerSource": "not-the-source"})).To(Succeed())
+
+				newManifest, _, _ := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+				annotations := newManifest.Layers[2].Annotations
+				Expect(annotations).To(HaveLen(4))
+				Expect(annotations).To(HaveKeyWithValue("hydrator.layerAdded", "true"))
+				Expect(annotations).To(HaveKeyWithValue("hydrator.layerSource", "my-new-layer.tgz"))
+				Expect(annotations).To(HaveKeyWithValue("some-key", "some-value"))
+
+				created, err := time.Parse(time.RFC3339, annotations["hydrator.layerCreated"])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(created).To(BeTemporally("~", time.Now(), time.Minute))
+			})
+
+			Context("the image has no history", func() {
+				BeforeEach(func() {
+					ociImageConfig.History = nil
+					fakeOCIDirectory.ReadMetadataReturns(manifest, ociImageConfig, nil)
+				})
+
+				It("does not start one", func() {
+					Expect(layerModifier.AddLayer(layerTgzPath, nil)).To(Succeed())
+
+					_, newConfig, _ := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+					Expect(newConfig.History).To(BeEmpty())
+				})
+			})
+
+			Context("the manifest is a docker manifest", func() {
+				BeforeEach(func() {
+					manifest.MediaType = "application/vnd.docker.distribution.manifest.v2+json"
+					fakeOCIDirectory.ReadMetadataReturns(manifest, ociImageConfig, nil)
+				})
+
+				It("adds the layer with the docker media type", func() {
+					Expect(layerModifier.AddLayer(layerTgzPath, nil)).To(Succeed())
+
+					newManifest, _, _ := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+					Expect(newManifest.MediaType).To(Equal("application/vnd.docker.distribution.manifest.v2+json"))
+					Expect(newManifest.Layers[2].MediaType).To(Equal("application/vnd.docker.image.rootfs.diff.tar.gzip"))
+				})
+			})
+
+			Context("Reading the index annotations fails", func() {
+				BeforeEach(func() {
+					fakeOCIDirectory.ReadIndexAnnotationsReturns(nil, errors.New("failed to read index.json"))
+				})
+
+				It("returns the error", func() {
+					Expect(layerModifier.AddLayer(layerTgzPath, nil)).To(MatchError("failed to read index.json"))
+
+					Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(0))
+					Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
+				})
 			})
 
 			Context("Adding the blob fails", func() {
@@ -131,7 +236,7 @@ var _ = Describe("LayerModifier", func() {
 				})
 
 				It("returns the error", func() {
-					Expect(layerModifier.AddLayer(layerTgzPath)).To(MatchError("failed to add blob"))
+					Expect(layerModifier.AddLayer(layerTgzPath, nil)).To(MatchError("failed to add blob"))
 
 					Expect(fakeOCIDirectory.ReadMetadataCallCount()).To(Equal(0))
 					Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(0))
@@ -145,7 +250,7 @@ var _ = Describe("LayerModifier", func() {
 				})
 
 				It("returns the error", func() {
-					Expect(layerModifier.AddLayer(layerTgzPath)).To(MatchError("failed to read metadata"))
+					Expect(layerModifier.AddLayer(layerTgzPath, nil)).To(MatchError("failed to read metadata"))
 
 					Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(0))
 					Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
@@ -158,7 +263,7 @@ var _ = Describe("LayerModifier", func() {
 				})
 
 				It("returns the error", func() {
-					Expect(layerModifier.AddLayer(layerTgzPath)).To(MatchError("failed to clear metadata"))
+					Expect(layerModifier.AddLayer(layerTgzPath, nil)).To(MatchError("failed to clear metadata"))
 
 					Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
 				})
@@ -170,7 +275,7 @@ var _ = Describe("LayerModifier", func() {
 				})
 
 				It("returns the error", func() {
-					Expect(layerModifier.AddLayer(layerTgzPath)).To(MatchError("failed to write metadata"))
+					Expect(layerModifier.AddLayer(layerTgzPath, nil)).To(MatchError("failed to write metadata"))
 				})
 			})
 		})
@@ -181,7 +286,7 @@ var _ = Describe("LayerModifier", func() {
 			})
 
 			It("returns an error", func() {
-				err := layerModifier.AddLayer(layerTgzPath)
+				err := layerModifier.AddLayer(layerTgzPath, nil)
 				Expect(err).To(HaveOccurred())
 				Expect(err).To(MatchError(fmt.Sprintf("invalid layer %s: not gzipped", layerTgzPath)))
 			})
@@ -189,7 +294,7 @@ var _ = Describe("LayerModifier", func() {
 
 		Context("the layer file does not exist", func() {
 			It("returns an error", func() {
-				err := layerModifier.AddLayer("invalid/layer/path")
+				err := layerModifier.AddLayer("invalid/layer/path", nil)
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -198,18 +303,17 @@ var _ = Describe("LayerModifier", func() {
 	Describe("RemoveHydratorLayer", func() {
 
 		It("removes the layer that was added by hydrator, and updates the OCI image metadata to not contain the hydrator layer", func() {
-			Expect(layerModifier.RemoveHydratorLayer()).To(Succeed())
+			Expect(layerModifier.RemoveHydratorLayers(1)).To(Succeed())
 
 			Expect(fakeOCIDirectory.ReadMetadataCallCount()).To(Equal(1))
 
 			Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(1))
 
-			Expect(fakeOCIDirectory.RemoveTopBlobCallCount()).To(Equal(1))
-			p := fakeOCIDirectory.RemoveTopBlobArgsForCall(0)
-			Expect(p).To(Equal("layer2"))
+			Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedCallCount()).To(Equal(1))
+			Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedArgsForCall(0)).To(Equal(digest.Digest("sha256:layer2")))
 
 			Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(1))
-			newLayers, newDiffIDs, layerAdded := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+			newManifest, newConfig, newIndexAnnotations := fakeOCIDirectory.WriteMetadataArgsForCall(0)
 
 			expectedLayers := []oci.Descriptor{
 				{Digest: "sha256:layer1", Size: 1234, MediaType: oci.MediaTypeImageLayerGzip},
@@ -219,9 +323,25 @@ var _ = Describe("LayerModifier", func() {
 				digest.NewDigestFromEncoded(digest.SHA256, "abcd"),
 			}
 
-			Expect(newLayers).To(Equal(expectedLayers))
-			Expect(newDiffIDs).To(Equal(expectedDiffIDs))
-			Expect(layerAdded).To(BeFalse())
+			Expect(newManifest.Layers).To(Equal(expectedLayers))
+			Expect(newConfig.RootFS.DiffIDs).To(Equal(expectedDiffIDs))
+			Expect(newConfig.Platform).To(Equal(oci.Platform{OS: "linux", Architecture: "arm64"}))
+			Expect(newManifest.Annotations).NotTo(HaveKey("hydrator.layerAdded"))
+			Expect(newIndexAnnotations).To(Equal(indexAnnotations))
+		})
+
+		It("keeps the rest of the manifest and config, and removes the history entry of the layer", func() {
+			Expect(layerModifier.RemoveHydratorLayers(1)).To(Succeed())
+
+			newManifest, newConfig, _ := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+			Expect(newManifest.Annotations).To(Equal(map[string]string{"hydrator.sourceImage": "some-image"}))
+
+			Expect(newConfig.Created).To(Equal(&created))
+			Expect(newConfig.Config).To(Equal(ociImageConfig.Config))
+			Expect(newConfig.History).To(Equal([]oci.History{
+				{Created: &created, CreatedBy: "some-base-layer"},
+				{Created: &created, CreatedBy: "ENV FOO=bar", EmptyLayer: true},
+			}))
 		})
 
 		Context("No layer was added previously", func() {
@@ -236,26 +356,110 @@ var _ = Describe("LayerModifier", func() {
 				fakeOCIDirectory.ReadMetadataReturns(manifest, ociImageConfig, nil)
 			})
 
-			It("leaves the image unchanged", func() {
-				Expect(layerModifier.RemoveHydratorLayer()).To(Succeed())
+			It("refuses to remove a base layer and leaves the image unchanged", func() {
+				Expect(layerModifier.RemoveHydratorLayers(1)).To(MatchError("cannot remove 1 layers: layer 1 (sha256:layer2) was not added by hydrator"))
 
 				Expect(fakeOCIDirectory.ReadMetadataCallCount()).To(Equal(1))
 				Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(0))
 				Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
 			})
+
+			It("does nothing when asked to remove all hydrator layers", func() {
+				Expect(layerModifier.RemoveHydratorLayers(0)).To(Succeed())
+
+				Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(0))
+				Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("several layers were added", func() {
+			added := map[string]string{"hydrator.layerAdded": "true"}
+
+			BeforeEach(func() {
+				manifest = oci.Manifest{
+					Layers: []oci.Descriptor{
+						{Digest: "sha256:layer1", Size: 1234, MediaType: oci.MediaTypeImageLayerGzip},
+						{Digest: "sha256:layer2", Size: 6789, MediaType: oci.MediaTypeImageLayerGzip, Annotations: added},
+						{Digest: "sha256:layer3", Size: 4321, MediaType: oci.MediaTypeImageLayerGzip, Annotations: added},
+					},
+					Annotations: map[string]string{"hydrator.layerAdded": "true"},
+				}
+				ociImageConfig.RootFS.DiffIDs = append(ociImageConfig.RootFS.DiffIDs, digest.NewDigestFromEncoded(digest.SHA256, "3456"))
+				ociImageConfig.History = append(ociImageConfig.History, oci.History{Created: &created, CreatedBy: "hydrate add-layer layer3.tgz"})
+				fakeOCIDirectory.ReadMetadataReturns(manifest, ociImageConfig, nil)
+			})
+
+			It("removes the top one and keeps the manifest annotation for the next", func() {
+				Expect(layerModifier.RemoveHydratorLayers(1)).To(Succeed())
+
+				Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedCallCount()).To(Equal(1))
+				Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedArgsForCall(0)).To(Equal(digest.Digest("sha256:layer3")))
+
+				newManifest, newConfig, _ := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+				Expect(newManifest.Layers).To(Equal(manifest.Layers[:2]))
+				Expect(newManifest.Annotations).To(HaveKeyWithValue("hydrator.layerAdded", "true"))
+				Expect(newConfig.RootFS.DiffIDs).To(HaveLen(2))
+				Expect(newConfig.History).To(HaveLen(3))
+			})
+
+			It("removes as many as it is asked to", func() {
+				Expect(layerModifier.RemoveHydratorLayers(2)).To(Succeed())
+
+				Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedCallCount()).To(Equal(2))
+				Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedArgsForCall(0)).To(Equal(digest.Digest("sha256:layer3")))
+				Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedArgsForCall(1)).To(Equal(digest.Digest("sha256:layer2")))
+
+				newManifest, newConfig, _ := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+				Expect(newManifest.Layers).To(Equal(manifest.Layers[:1]))
+				Expect(newManifest.Annotations).NotTo(HaveKey("hydrator.layerAdded"))
+				Expect(newConfig.RootFS.DiffIDs).To(Equal(ociImageConfig.RootFS.DiffIDs[:1]))
+				Expect(newConfig.History).To(Equal(ociImageConfig.History[:2]))
+			})
+
+			It("removes all of them when the count is 0", func() {
+				Expect(layerModifier.RemoveHydratorLayers(0)).To(Succeed())
+
+				Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedCallCount()).To(Equal(2))
+				newManifest, _, _ := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+				Expect(newManifest.Layers).To(Equal(manifest.Layers[:1]))
+			})
+
+			It("refuses to remove the base layers and leaves the image unchanged", func() {
+				Expect(layerModifier.RemoveHydratorLayers(3)).To(MatchError("cannot remove 3 layers: layer 0 (sha256:layer1) was not added by hydrator"))
+
+				Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(0))
+				Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedCallCount()).To(Equal(0))
+				Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
+			})
+
+			Context("the same layer was added twice", func() {
+				BeforeEach(func() {
+					manifest.Layers[2].Digest = "sha256:layer2"
+					fakeOCIDirectory.ReadMetadataReturns(manifest, ociImageConfig, nil)
+				})
+
+				It("only removes the blob once the new manifest no longer refers to it", func() {
+					Expect(layerModifier.RemoveHydratorLayers(1)).To(Succeed())
+
+					newManifest, _, _ := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+					Expect(newManifest.Layers).To(ContainElement(HaveField("Digest", digest.Digest("sha256:layer2"))))
+					Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedCallCount()).To(Equal(1))
+					Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedArgsForCall(0)).To(Equal(digest.Digest("sha256:layer2")))
+				})
+			})
 		})
 
 		Context("Removing the blob fails", func() {
 			BeforeEach(func() {
-				fakeOCIDirectory.RemoveTopBlobReturns(errors.New("failed to remove blob"))
+				fakeOCIDirectory.RemoveBlobIfUnreferencedReturns(false, errors.New("failed to remove blob"))
 			})
 
-			It("returns the error", func() {
-				Expect(layerModifier.RemoveHydratorLayer()).To(MatchError("failed to remove blob"))
+			It("returns the error after writing the new metadata", func() {
+				Expect(layerModifier.RemoveHydratorLayers(1)).To(MatchError("failed to remove blob"))
 
 				Expect(fakeOCIDirectory.ReadMetadataCallCount()).To(Equal(1))
 				Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(1))
-				Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
+				Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(1))
 			})
 		})
 
@@ -265,7 +469,7 @@ var _ = Describe("LayerModifier", func() {
 			})
 
 			It("returns the error", func() {
-				Expect(layerModifier.RemoveHydratorLayer()).To(MatchError("failed to read metadata"))
+				Expect(layerModifier.RemoveHydratorLayers(1)).To(MatchError("failed to read metadata"))
 
 				Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(0))
 				Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
@@ -278,7 +482,7 @@ var _ = Describe("LayerModifier", func() {
 			})
 
 			It("returns the error", func() {
-				Expect(layerModifier.RemoveHydratorLayer()).To(MatchError("failed to clear metadata"))
+				Expect(layerModifier.RemoveHydratorLayers(1)).To(MatchError("failed to clear metadata"))
 
 				Expect(fakeOCIDirectory.ReadMetadataCallCount()).To(Equal(1))
 				Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
@@ -291,10 +495,353 @@ var _ = Describe("LayerModifier", func() {
 			})
 
 			It("returns the error", func() {
-				Expect(layerModifier.RemoveHydratorLayer()).To(MatchError("failed to write metadata"))
+				Expect(layerModifier.RemoveHydratorLayers(1)).To(MatchError("failed to write metadata"))
 				Expect(fakeOCIDirectory.ReadMetadataCallCount()).To(Equal(1))
 				Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(1))
 			})
 		})
 	})
+
+	Describe("RemoveHydratorLayer", func() {
+		It("removes the top layer when hydrator added it", func() {
+			Expect(layerModifier.RemoveHydratorLayer()).To(Succeed())
+
+			Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(1))
+			newManifest, newConfig, _ := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+			Expect(newManifest.Layers).To(Equal(manifest.Layers[:1]))
+			Expect(newConfig.RootFS.DiffIDs).To(Equal(ociImageConfig.RootFS.DiffIDs[:1]))
+			Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedArgsForCall(0)).To(Equal(digest.Digest("sha256:layer2")))
+		})
+
+		Context("the top layer was not added by hydrator", func() {
+			BeforeEach(func() {
+				delete(manifest.Annotations, "hydrator.layerAdded")
+				fakeOCIDirectory.ReadMetadataReturns(manifest, ociImageConfig, nil)
+			})
+
+			It("succeeds without changing the image", func() {
+				Expect(layerModifier.RemoveHydratorLayer()).To(Succeed())
+
+				Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(0))
+				Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
+				Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("Reading the metadata fails", func() {
+			BeforeEach(func() {
+				fakeOCIDirectory.ReadMetadataReturns(oci.Manifest{}, oci.Image{}, errors.New("failed to read metadata"))
+			})
+
+			It("returns the error", func() {
+				Expect(layerModifier.RemoveHydratorLayer()).To(MatchError("failed to read metadata"))
+			})
+		})
+	})
+
+	Describe("RemoveLayer", func() {
+		added := map[string]string{"hydrator.layerAdded": "true"}
+
+		BeforeEach(func() {
+			manifest = oci.Manifest{
+				Layers: []oci.Descriptor{
+					{Digest: "sha256:layer1", Size: 1234, MediaType: oci.MediaTypeImageLayerGzip},
+					{Digest: "sha256:layer2", Size: 6789, MediaType: oci.MediaTypeImageLayerGzip, Annotations: added},
+					{Digest: "sha256:layer3", Size: 4321, MediaType: oci.MediaTypeImageLayerGzip, Annotations: added},
+				},
+				Annotations: map[string]string{"hydrator.layerAdded": "true"},
+			}
+			ociImageConfig.RootFS.DiffIDs = append(ociImageConfig.RootFS.DiffIDs, digest.NewDigestFromEncoded(digest.SHA256, "3456"))
+			ociImageConfig.History = append(ociImageConfig.History, oci.History{Created: &created, CreatedBy: "hydrate add-layer layer3.tgz"})
+			fakeOCIDirectory.ReadMetadataReturns(manifest, ociImageConfig, nil)
+		})
+
+		It("removes a layer from the middle of the image with its diffID and history entry", func() {
+			Expect(layerModifier.RemoveLayer(1, false)).To(Succeed())
+
+			Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(1))
+			Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(1))
+			newManifest, newConfig, newIndexAnnotations := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+			Expect(newManifest.Layers).To(Equal([]oci.Descriptor{manifest.Layers[0], manifest.Layers[2]}))
+			Expect(newManifest.Annotations).To(HaveKeyWithValue("hydrator.layerAdded", "true"))
+			Expect(newConfig.RootFS.DiffIDs).To(Equal([]digest.Digest{ociImageConfig.RootFS.DiffIDs[0], ociImageConfig.RootFS.DiffIDs[2]}))
+			Expect(newConfig.History).To(Equal([]oci.History{ociImageConfig.History[0], ociImageConfig.History[1], ociImageConfig.History[3]}))
+			Expect(newIndexAnnotations).To(Equal(indexAnnotations))
+
+			Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedCallCount()).To(Equal(1))
+			Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedArgsForCall(0)).To(Equal(digest.Digest("sha256:layer2")))
+		})
+
+		It("removes a layer by its digest", func() {
+			Expect(layerModifier.RemoveLayerByDigest("sha256:layer3", false)).To(Succeed())
+
+			newManifest, newConfig, _ := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+			Expect(newManifest.Layers).To(Equal(manifest.Layers[:2]))
+			Expect(newConfig.RootFS.DiffIDs).To(Equal(ociImageConfig.RootFS.DiffIDs[:2]))
+			Expect(newConfig.History).To(Equal(ociImageConfig.History[:3]))
+			Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedArgsForCall(0)).To(Equal(digest.Digest("sha256:layer3")))
+		})
+
+		It("refuses to remove a layer that was not added by hydrator", func() {
+			Expect(layerModifier.RemoveLayer(0, false)).To(MatchError("layer 0 (sha256:layer1) was not added by hydrator, use -force to remove it"))
+			Expect(layerModifier.RemoveLayerByDigest("sha256:layer1", false)).To(MatchError("layer 0 (sha256:layer1) was not added by hydrator, use -force to remove it"))
+
+			Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(0))
+			Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
+			Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedCallCount()).To(Equal(0))
+		})
+
+		It("removes a layer that was not added by hydrator when forced", func() {
+			Expect(layerModifier.RemoveLayer(0, true)).To(Succeed())
+
+			newManifest, newConfig, _ := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+			Expect(newManifest.Layers).To(Equal(manifest.Layers[1:]))
+			Expect(newConfig.RootFS.DiffIDs).To(Equal(ociImageConfig.RootFS.DiffIDs[1:]))
+			Expect(newConfig.History).To(Equal(ociImageConfig.History[1:]))
+			Expect(fakeOCIDirectory.RemoveBlobIfUnreferencedArgsForCall(0)).To(Equal(digest.Digest("sha256:layer1")))
+		})
+
+		It("removes the manifest annotation when the new top layer was not added by hydrator", func() {
+			manifest.Layers = manifest.Layers[:2]
+			ociImageConfig.RootFS.DiffIDs = ociImageConfig.RootFS.DiffIDs[:2]
+			fakeOCIDirectory.ReadMetadataReturns(manifest, ociImageConfig, nil)
+
+			Expect(layerModifier.RemoveLayer(1, false)).To(Succeed())
+
+			newManifest, _, _ := fakeOCIDirectory.WriteMetadataArgsForCall(0)
+			Expect(newManifest.Layers).To(Equal(manifest.Layers[:1]))
+			Expect(newManifest.Annotations).NotTo(HaveKey("hydrator.layerAdded"))
+		})
+
+		It("returns an error if the index is out of range", func() {
+			Expect(layerModifier.RemoveLayer(3, false)).To(MatchError("invalid layer index 3: the image has 3 layers"))
+			Expect(layerModifier.RemoveLayer(-1, false)).To(MatchError("invalid layer index -1: the image has 3 layers"))
+			Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
+		})
+
+		It("returns an error if the image does not contain the digest", func() {
+			Expect(layerModifier.RemoveLayerByDigest("sha256:layer4", false)).To(MatchError("image does not contain layer sha256:layer4"))
+			Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
+		})
+
+		Context("the same layer was added twice", func() {
+			BeforeEach(func() {
+				manifest.Layers[2].Digest = "sha256:layer2"
+				fakeOCIDirectory.ReadMetadataReturns(manifest, ociImageConfig, nil)
+			})
+
+			It("refuses to guess which one to remove by digest", func() {
+				Expect(layerModifier.RemoveLayerByDigest("sha256:layer2", false)).To(MatchError("layer sha256:layer2 appears more than once in the image, remove it by index"))
+				Expect(fakeOCIDirectory.WriteMetadataCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("Reading the metadata fails", func() {
+			BeforeEach(func() {
+				fakeOCIDirectory.ReadMetadataReturns(oci.Manifest{}, oci.Image{}, errors.New("failed to read metadata"))
+			})
+
+			It("returns the error", func() {
+				Expect(layerModifier.RemoveLayer(1, false)).To(MatchError("failed to read metadata"))
+				Expect(layerModifier.RemoveLayerByDigest("sha256:layer2", false)).To(MatchError("failed to read metadata"))
+				Expect(fakeOCIDirectory.ClearMetadataCallCount()).To(Equal(0))
+			})
+		})
+	})
 })
+
+var _ = Describe("LayerModifier on an OCI layout with several manifests", func() {
+	var (
+		ociImageDir string
+		layers      []oci.Descriptor
+		config      oci.Descriptor
+		image       oci.Descriptor
+		other       oci.Descriptor
+	)
+
+	BeforeEach(func() {
+		var err error
+		ociImageDir, err = os.MkdirTemp("", "layermodifier-oci-image")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.MkdirAll(filepath.Join(ociImageDir, "blobs", "sha256"), 0755)).To(Succeed())
+
+		layers = []oci.Descriptor{
+			writeTestBlob(ociImageDir, []byte("some-base-layer"), oci.MediaTypeImageLayerGzip),
+			writeTestBlob(ociImageDir, []byte("some-hydrator-layer"), oci.MediaTypeImageLayerGzip),
+		}
+		layers[1].Annotations = map[string]string{"hydrator.layerAdded": "true"}
+
+		config = writeTestJSON(ociImageDir, oci.Image{
+			Platform: oci.Platform{OS: "windows", Architecture: "amd64"},
+			RootFS: oci.RootFS{Type: "layers", DiffIDs: []digest.Digest{
+				digest.FromString("some-base-layer"),
+				digest.FromString("some-hydrator-layer"),
+			}},
+		}, oci.MediaTypeImageConfig)
+
+		image = writeTestJSON(ociImageDir, oci.Manifest{
+			Versioned:   specs.Versioned{SchemaVersion: 2},
+			MediaType:   oci.MediaTypeImageManifest,
+			Config:      config,
+			Layers:      layers,
+			Annotations: map[string]string{"hydrator.layerAdded": "true"},
+		}, oci.MediaTypeImageManifest)
+	})
+
+	JustBeforeEach(func() {
+		writeTestIndex(ociImageDir, oci.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			Manifests: []oci.Descriptor{image, other},
+		})
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(ociImageDir)).To(Succeed())
+	})
+
+	Context("the other manifest shares the config and layers", func() {
+		BeforeEach(func() {
+			other = writeTestJSON(ociImageDir, oci.Manifest{
+				Versioned:   specs.Versioned{SchemaVersion: 2},
+				MediaType:   oci.MediaTypeImageManifest,
+				Config:      config,
+				Layers:      layers,
+				Annotations: map[string]string{"some-key": "some-value"},
+			}, oci.MediaTypeImageManifest)
+		})
+
+		It("removes the layer from the image and keeps the blobs the other manifest refers to", func() {
+			l := layermodifier.New(directory.NewHandler(ociImageDir))
+			Expect(l.RemoveHydratorLayers(1)).To(Succeed())
+
+			index := readTestIndex(ociImageDir)
+			Expect(index.Manifests).To(HaveLen(2))
+			Expect(index.Manifests[1]).To(Equal(other))
+
+			m, c, err := directory.NewHandler(ociImageDir).ReadMetadata()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m.Layers).To(Equal(layers[:1]))
+			Expect(c.RootFS.DiffIDs).To(HaveLen(1))
+
+			Expect(testBlobPath(ociImageDir, layers[1].Digest)).To(BeAnExistingFile())
+			Expect(testBlobPath(ociImageDir, config.Digest)).To(BeAnExistingFile())
+			Expect(testBlobPath(ociImageDir, image.Digest)).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("the other manifest only shares the base layer", func() {
+		BeforeEach(func() {
+			otherConfig := writeTestJSON(ociImageDir, oci.Image{
+				Platform: oci.Platform{OS: "windows", Architecture: "amd64"},
+				RootFS:   oci.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.FromString("some-base-layer")}},
+			}, oci.MediaTypeImageConfig)
+
+			other = writeTestJSON(ociImageDir, oci.Manifest{
+				Versioned: specs.Versioned{SchemaVersion: 2},
+				MediaType: oci.MediaTypeImageManifest,
+				Config:    otherConfig,
+				Layers:    layers[:1],
+			}, oci.MediaTypeImageManifest)
+		})
+
+		It("removes the blobs that no manifest refers to any more", func() {
+			l := layermodifier.New(directory.NewHandler(ociImageDir))
+			Expect(l.RemoveLayerByDigest(layers[1].Digest, false)).To(Succeed())
+
+			index := readTestIndex(ociImageDir)
+			Expect(index.Manifests).To(HaveLen(2))
+			Expect(index.Manifests[1]).To(Equal(other))
+
+			Expect(testBlobPath(ociImageDir, layers[0].Digest)).To(BeAnExistingFile())
+			Expect(testBlobPath(ociImageDir, layers[1].Digest)).NotTo(BeAnExistingFile())
+			Expect(testBlobPath(ociImageDir, config.Digest)).NotTo(BeAnExistingFile())
+			Expect(testBlobPath(ociImageDir, image.Digest)).NotTo(BeAnExistingFile())
+		})
+	})
+})
+
+var _ = Describe("LayerModifier on an OCI layout with index annotations", func() {
+	var ociImageDir string
+
+	BeforeEach(func() {
+		var err error
+		ociImageDir, err = os.MkdirTemp("", "layermodifier-oci-image")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.MkdirAll(filepath.Join(ociImageDir, "blobs", "sha256"), 0755)).To(Succeed())
+
+		layers := []oci.Descriptor{
+			writeTestBlob(ociImageDir, []byte("some-base-layer"), oci.MediaTypeImageLayerGzip),
+			writeTestBlob(ociImageDir, []byte("some-hydrator-layer"), oci.MediaTypeImageLayerGzip),
+		}
+		layers[1].Annotations = map[string]string{"hydrator.layerAdded": "true"}
+
+		config := writeTestJSON(ociImageDir, oci.Image{
+			Platform: oci.Platform{OS: "windows", Architecture: "amd64"},
+			RootFS: oci.RootFS{Type: "layers", DiffIDs: []digest.Digest{
+				digest.FromString("some-base-layer"),
+				digest.FromString("some-hydrator-layer"),
+			}},
+		}, oci.MediaTypeImageConfig)
+
+		image := writeTestJSON(ociImageDir, oci.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: oci.MediaTypeImageManifest,
+			Config:    config,
+			Layers:    layers,
+		}, oci.MediaTypeImageManifest)
+
+		writeTestIndex(ociImageDir, oci.Index{
+			Versioned:   specs.Versioned{SchemaVersion: 2},
+			Manifests:   []oci.Descriptor{image},
+			Annotations: map[string]string{oci.AnnotationRefName: "some-ref"},
+		})
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(ociImageDir)).To(Succeed())
+	})
+
+	It("keeps the annotations of index.json", func() {
+		l := layermodifier.New(directory.NewHandler(ociImageDir))
+		Expect(l.RemoveHydratorLayers(1)).To(Succeed())
+
+		index := readTestIndex(ociImageDir)
+		Expect(index.Annotations).To(Equal(map[string]string{oci.AnnotationRefName: "some-ref"}))
+		Expect(index.Manifests).To(HaveLen(1))
+
+		m, _, err := directory.NewHandler(ociImageDir).ReadMetadata()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Layers).To(HaveLen(1))
+	})
+})
+
+func writeTestBlob(ociImageDir string, data []byte, mediaType string) oci.Descriptor {
+	d := digest.FromBytes(data)
+	Expect(os.WriteFile(testBlobPath(ociImageDir, d), data, 0644)).To(Succeed())
+	return oci.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(data))}
+}
+
+func writeTestJSON(ociImageDir string, obj interface{}, mediaType string) oci.Descriptor {
+	data, err := json.Marshal(obj)
+	Expect(err).NotTo(HaveOccurred())
+	return writeTestBlob(ociImageDir, data, mediaType)
+}
+
+func writeTestIndex(ociImageDir string, index oci.Index) {
+	data, err := json.Marshal(index)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(os.WriteFile(filepath.Join(ociImageDir, "index.json"), data, 0644)).To(Succeed())
+}
+
+func readTestIndex(ociImageDir string) oci.Index {
+	data, err := os.ReadFile(filepath.Join(ociImageDir, "index.json"))
+	Expect(err).NotTo(HaveOccurred())
+
+	var index oci.Index
+	Expect(json.Unmarshal(data, &index)).To(Succeed())
+	return index
+}
+
+func testBlobPath(ociImageDir string, d digest.Digest) string {
+	return filepath.Join(ociImageDir, "blobs", "sha256", d.Encoded())
+}
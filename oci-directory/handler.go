@@ -1,6 +1,7 @@
 package directory
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -13,12 +14,17 @@ import (
 
 type Handler struct {
 	ociImageDir string
+	// configs are the fields of each config that ReadMetadata read
+	configs map[digest.Digest]map[string]json.RawMessage
+	// clearedIndex is the index.json that ClearMetadata removed
+	clearedIndex *oci.Index
 }
 
 func NewHandler(oid string) *Handler {
 	return &Handler{
 		/* handle both oci directory path and oci:///<directory-path> */
 		ociImageDir: strings.TrimPrefix(oid, "oci:///"),
+		configs:     make(map[digest.Digest]map[string]json.RawMessage),
 	}
 }
 
@@ -40,53 +46,118 @@ func (h *Handler) AddBlob(srcBlobPath string, blobDescriptor oci.Descriptor) err
 		return err
 	}
 
-	destfd, err := os.Create(h.blobsPath(blobDescriptor.Digest.Encoded()))
+	blobPath := h.blobsPath(blobDescriptor.Digest.Encoded())
+	destfd, err := os.Create(blobPath)
 	if err != nil {
 		return err
 	}
-	defer destfd.Close()
 
-	_, err = io.Copy(destfd, layerfd)
-	return err
+	err = copyVerified(destfd, layerfd, blobDescriptor)
+	if closeErr := destfd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(blobPath)
+		return fmt.Errorf("%s: %s", srcBlobPath, err)
+	}
+	return nil
 }
 
-func (h *Handler) RemoveTopBlob(sha256 string) error {
-	if _, err := os.Stat(h.blobsDir()); err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("%s is not a valid OCI image: %s directory missing", h.ociImageDir, h.blobsDir())
-		}
+// copyVerified checks the blob against its descriptor as it is copied, so that
+// ReadMetadata does not need to read it again. A size of zero is not checked.
+func copyVerified(dest io.Writer, src io.Reader, desc oci.Descriptor) error {
+	if desc.Size > 0 {
+		src = io.LimitReader(src, desc.Size+1)
+	}
+
+	verifier := desc.Digest.Verifier()
+	n, err := io.Copy(io.MultiWriter(dest, verifier), src)
+	if err != nil {
 		return err
 	}
 
-	if err := os.Remove(h.blobsPath(sha256)); err != nil {
+	if desc.Size > 0 && n != desc.Size {
+		return fmt.Errorf("size mismatch: expected %d bytes, found %d", desc.Size, n)
+	}
+
+	if !verifier.Verified() {
+		return fmt.Errorf("%s mismatch: expected %s", desc.Digest.Algorithm(), desc.Digest)
+	}
+	return nil
+}
+
+// RemoveBlobIfUnreferenced removes the blob unless it is a manifest listed in
+// index.json, or the config or a layer of one, and returns whether it did
+func (h *Handler) RemoveBlobIfUnreferenced(blob digest.Digest) (bool, error) {
+	var i oci.Index
+	if _, err := loadJSON(h.indexPath(), &i); err != nil {
+		return false, fmt.Errorf("couldn't load index.json: %s", err.Error())
+	}
+
+	return h.removeUnreferenced(blob, i.Manifests)
+}
+
+func (h *Handler) removeUnreferenced(blob digest.Digest, manifests []oci.Descriptor) (bool, error) {
+	for _, mDesc := range manifests {
+		if mDesc.Digest == blob {
+			return false, nil
+		}
+
+		var m oci.Manifest
+		if _, err := loadJSON(h.blobsPathFromDescriptor(mDesc), &m); err != nil {
+			return false, fmt.Errorf("couldn't load manifest: %s", err.Error())
+		}
+
+		if m.Config.Digest == blob {
+			return false, nil
+		}
+		for _, l := range m.Layers {
+			if l.Digest == blob {
+				return false, nil
+			}
+		}
+	}
+
+	if err := os.Remove(h.blobsPath(blob.Encoded())); err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("%s does not contain layer: %s", h.ociImageDir, sha256)
+			return false, nil
 		}
-		return err
+		return false, err
 	}
-	return nil
+	return true, nil
 }
 
+// ClearMetadata removes the first manifest in index.json and its config,
+// unless another manifest in index.json refers to them. While index.json lists
+// other manifests it is kept, with oci-layout, so that WriteMetadata puts the
+// new manifest in place of the first one. Otherwise WriteMetadata writes the
+// removed index.json again with the new manifest, keeping its annotations.
 func (h *Handler) ClearMetadata() error {
-	filesToDelete := []string{h.ociLayoutPath(), h.indexPath()}
-
 	i, err := h.loadIndex()
 	if err != nil {
 		return fmt.Errorf("couldn't load index.json: %s", err.Error())
 	}
 
 	mDesc := i.Manifests[0]
-	filesToDelete = append(filesToDelete, h.blobsPathFromDescriptor(mDesc))
-
 	m, err := h.loadManifest(mDesc)
 	if err != nil {
 		return fmt.Errorf("couldn't load manifest: %s", err.Error())
 	}
-	filesToDelete = append(filesToDelete, h.blobsPathFromDescriptor(m.Config))
+
+	others := i.Manifests[1:]
 
 	var errRet error
-	for _, f := range filesToDelete {
-		if err := os.RemoveAll(f); err != nil {
+	if len(others) == 0 {
+		h.clearedIndex = &i
+		for _, f := range []string{h.ociLayoutPath(), h.indexPath()} {
+			if err := os.RemoveAll(f); err != nil {
+				errRet = err
+			}
+		}
+	}
+
+	for _, blob := range []digest.Digest{mDesc.Digest, m.Config.Digest} {
+		if _, err := h.removeUnreferenced(blob, others); err != nil {
 			errRet = err
 		}
 	}
@@ -72,6 +72,32 @@ var _ = Describe("Handler", func() {
 			})
 		})
 
+		Context("the layer does not match the descriptor digest", func() {
+			It("returns an error and does not keep the blob", func() {
+				otherSHA256 := "a4dce48a216523fad0e7932218c9e5e6d6a4753df784ed2f6ec4e5ac9405e2a5"
+				layerDescriptor := oci.Descriptor{
+					Digest: digest.NewDigestFromEncoded("sha256", otherSHA256),
+				}
+
+				err := h.AddBlob(layerTgzPath, layerDescriptor)
+				Expect(err).To(MatchError(fmt.Sprintf("%s: sha256 mismatch: expected sha256:%s", layerTgzPath, otherSHA256)))
+				Expect(filepath.Join(ociImageDir, "blobs", "sha256", otherSHA256)).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("the layer does not match the descriptor size", func() {
+			It("returns an error and does not keep the blob", func() {
+				layerDescriptor := oci.Descriptor{
+					Digest: digest.NewDigestFromEncoded("sha256", layerTgzSHA256),
+					Size:   int64(len(layerTgzContents)) - 1,
+				}
+
+				err := h.AddBlob(layerTgzPath, layerDescriptor)
+				Expect(err).To(MatchError(fmt.Sprintf("%s: size mismatch: expected %d bytes, found %d", layerTgzPath, len(layerTgzContents)-1, len(layerTgzContents))))
+				Expect(filepath.Join(ociImageDir, "blobs", "sha256", layerTgzSHA256)).NotTo(BeAnExistingFile())
+			})
+		})
+
 		Context("the provided layer descriptor has an invalid digest", func() {
 			It("returns a useful error", func() {
 				err := h.AddBlob(layerTgzPath, oci.Descriptor{Digest: "notadigest"})
@@ -80,44 +106,81 @@ var _ = Describe("Handler", func() {
 		})
 	})
 
-	Describe("RemoveTopBlob", func() {
-		Context("the oci image directory has a blobs/sha256 sub directory", func() {
-			Context("the layer exists in the directory", func() {
-				BeforeEach(func() {
-					layerDescriptor := oci.Descriptor{
-						Digest: digest.NewDigestFromEncoded("sha256", layerTgzSHA256),
-					}
+	Describe("RemoveBlobIfUnreferenced", func() {
+		const (
+			layer1 = "some-layer"
+			layer2 = "some-other-layer"
+		)
 
-					Expect(h.AddBlob(layerTgzPath, layerDescriptor)).To(Succeed())
-				})
+		var (
+			layers []oci.Descriptor
+			index  oci.Index
+		)
+
+		BeforeEach(func() {
+			layers = []oci.Descriptor{
+				{Digest: writeLayer(ociImageDir, layer1), MediaType: oci.MediaTypeImageLayerGzip, Size: int64(len(layer1))},
+				{Digest: writeLayer(ociImageDir, layer2), MediaType: oci.MediaTypeImageLayerGzip, Size: int64(len(layer2))},
+			}
 
-				It("removes the layer from the blobs/sha256 sub directory", func() {
-					_, err := os.ReadFile(filepath.Join(ociImageDir, "blobs", "sha256", layerTgzSHA256))
-					Expect(err).ToNot(HaveOccurred())
+			config := writeBlob(ociImageDir, oci.Image{Platform: oci.Platform{OS: "windows", Architecture: "amd64"}})
+			config.MediaType = oci.MediaTypeImageConfig
 
-					Expect(h.RemoveTopBlob(layerTgzSHA256)).To(Succeed())
+			manifest := writeBlob(ociImageDir, oci.Manifest{Config: config, Layers: layers[:1]})
+			manifest.MediaType = oci.MediaTypeImageManifest
 
-					_, err = os.ReadFile(filepath.Join(ociImageDir, "blobs", "sha256", layerTgzSHA256))
-					Expect(err).To(HaveOccurred())
-				})
-			})
+			index = oci.Index{Manifests: []oci.Descriptor{manifest}}
+			writeIndex(ociImageDir, index)
 		})
 
-		Context("the layer does not exist in the directory", func() {
-			It("returns a useful error", func() {
-				err := h.RemoveTopBlob(layerTgzSHA256)
-				Expect(err).To(MatchError(fmt.Sprintf("%s does not contain layer: %s", ociImageDir, layerTgzSHA256)))
-			})
+		It("removes a blob that no manifest refers to", func() {
+			removed, err := h.RemoveBlobIfUnreferenced(layers[1].Digest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(BeTrue())
+			Expect(filepath.Join(ociImageDir, "blobs", "sha256", layers[1].Digest.Encoded())).NotTo(BeAnExistingFile())
 		})
 
-		Context("the oci image directory does not have a blobs/sha256 sub directory", func() {
+		It("keeps a layer of the manifest", func() {
+			removed, err := h.RemoveBlobIfUnreferenced(layers[0].Digest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(BeFalse())
+			Expect(filepath.Join(ociImageDir, "blobs", "sha256", layers[0].Digest.Encoded())).To(BeAnExistingFile())
+		})
+
+		It("keeps the manifest and its config", func() {
+			m := loadManifest(ociImageDir)
+
+			removed, err := h.RemoveBlobIfUnreferenced(m.Config.Digest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(BeFalse())
+
+			removed, err = h.RemoveBlobIfUnreferenced(index.Manifests[0].Digest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(removed).To(BeFalse())
+		})
+
+		Context("another manifest in index.json refers to the blob", func() {
 			BeforeEach(func() {
-				Expect(os.RemoveAll(filepath.Join(ociImageDir, "blobs", "sha256"))).To(Succeed())
+				other := writeBlob(ociImageDir, oci.Manifest{Config: loadManifest(ociImageDir).Config, Layers: layers})
+				other.MediaType = oci.MediaTypeImageManifest
+
+				index.Manifests = append(index.Manifests, other)
+				writeIndex(ociImageDir, index)
 			})
 
-			It("returns a useful error", func() {
-				err := h.RemoveTopBlob(layerTgzSHA256)
-				Expect(err).To(MatchError(fmt.Sprintf("%s is not a valid OCI image: %s directory missing", ociImageDir, filepath.Join(ociImageDir, "blobs", "sha256"))))
+			It("keeps it", func() {
+				removed, err := h.RemoveBlobIfUnreferenced(layers[1].Digest)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(removed).To(BeFalse())
+				Expect(filepath.Join(ociImageDir, "blobs", "sha256", layers[1].Digest.Encoded())).To(BeAnExistingFile())
+			})
+		})
+
+		Context("the blob does not exist", func() {
+			It("does nothing", func() {
+				removed, err := h.RemoveBlobIfUnreferenced(digest.FromString("not-a-blob"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(removed).To(BeFalse())
 			})
 		})
 	})
@@ -146,13 +209,17 @@ var _ = Describe("Handler", func() {
 			}
 
 			layers = []oci.Descriptor{
-				{Digest: writeLayer(ociImageDir, layer1), MediaType: oci.MediaTypeImageLayerGzip},
-				{Digest: writeLayer(ociImageDir, layer2), MediaType: oci.MediaTypeImageLayerGzip},
-				{Digest: writeLayer(ociImageDir, layer3), MediaType: oci.MediaTypeImageLayerGzip},
+				{Digest: writeLayer(ociImageDir, layer1), MediaType: oci.MediaTypeImageLayerGzip, Size: int64(len(layer1))},
+				{Digest: writeLayer(ociImageDir, layer2), MediaType: oci.MediaTypeImageLayerGzip, Size: int64(len(layer2))},
+				{Digest: writeLayer(ociImageDir, layer3), MediaType: oci.MediaTypeImageLayerGzip, Size: int64(len(layer3))},
 			}
 
 			h = directory.NewHandler(ociImageDir)
-			Expect(h.WriteMetadata(layers, diffIds, false)).To(Succeed())
+			config := oci.Image{
+				Platform: oci.Platform{OS: "windows", Architecture: "amd64"},
+				RootFS:   oci.RootFS{Type: "layers", DiffIDs: diffIds},
+			}
+			Expect(h.WriteMetadata(oci.Manifest{Layers: layers}, config, nil)).To(Succeed())
 		})
 
 		AfterEach(func() {
@@ -174,6 +241,51 @@ var _ = Describe("Handler", func() {
 			})
 		})
 
+		Context("index.json has annotations of its own", func() {
+			BeforeEach(func() {
+				i := loadIndex(ociImageDir)
+				i.Annotations = map[string]string{oci.AnnotationRefName: "some-ref"}
+				writeIndex(ociImageDir, i)
+			})
+
+			It("writes them back with the new manifest", func() {
+				m, c, err := h.ReadMetadata()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(h.ClearMetadata()).To(Succeed())
+				Expect(h.WriteMetadata(oci.Manifest{Layers: m.Layers[:2]}, oci.Image{Platform: c.Platform, RootFS: oci.RootFS{Type: "layers", DiffIDs: c.RootFS.DiffIDs[:2]}}, nil)).To(Succeed())
+
+				i := loadIndex(ociImageDir)
+				Expect(i.Annotations).To(Equal(map[string]string{oci.AnnotationRefName: "some-ref"}))
+				Expect(i.Manifests).To(HaveLen(1))
+				Expect(loadManifest(ociImageDir).Layers).To(Equal(m.Layers[:2]))
+			})
+		})
+
+		Context("index.json lists another manifest that shares the config", func() {
+			var other oci.Descriptor
+
+			BeforeEach(func() {
+				i := loadIndex(ociImageDir)
+				other = writeBlob(ociImageDir, oci.Manifest{Config: loadManifest(ociImageDir).Config, Layers: layers[:1]})
+				other.MediaType = oci.MediaTypeImageManifest
+
+				i.Manifests = append(i.Manifests, other)
+				writeIndex(ociImageDir, i)
+			})
+
+			It("keeps index.json and the shared config, and only removes the first manifest", func() {
+				m := loadManifest(ociImageDir)
+				first := loadIndex(ociImageDir).Manifests[0]
+
+				Expect(h.ClearMetadata()).To(Succeed())
+				Expect(filepath.Join(ociImageDir, "oci-layout")).To(BeAnExistingFile())
+				Expect(filepath.Join(ociImageDir, "blobs", "sha256", m.Config.Digest.Encoded())).To(BeAnExistingFile())
+				Expect(filepath.Join(ociImageDir, "blobs", "sha256", first.Digest.Encoded())).NotTo(BeAnExistingFile())
+				Expect(loadIndex(ociImageDir).Manifests[1]).To(Equal(other))
+			})
+		})
+
 		Context("the index file does not exist", func() {
 			BeforeEach(func() {
 				Expect(os.Remove(filepath.Join(ociImageDir, "index.json"))).To(Succeed())
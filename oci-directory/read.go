@@ -4,12 +4,21 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 
 	oci "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+/* docker media types, which are kept by WriteOriginalMetadata */
+const (
+	dockerManifest               = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerConfig                 = "application/vnd.docker.container.image.v1+json"
+	dockerLayer                  = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	dockerForeignLayer           = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+	ociNonDistributableLayer     = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+	ociNonDistributableLayerGzip = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+)
+
 func (h *Handler) ReadMetadata() (oci.Manifest, oci.Image, error) {
 	i, err := h.loadIndex()
 	if err != nil {
@@ -27,6 +36,10 @@ func (h *Handler) ReadMetadata() (oci.Manifest, oci.Image, error) {
 		return oci.Manifest{}, oci.Image{}, fmt.Errorf("couldn't load image config: %s", err.Error())
 	}
 
+	if err := validatePlatform(mDesc.Platform, c.Platform); err != nil {
+		return oci.Manifest{}, oci.Image{}, err
+	}
+
 	if len(m.Layers) != len(c.RootFS.DiffIDs) {
 		return oci.Manifest{}, oci.Image{}, fmt.Errorf("manifest + config mismatch: %d layers, %d diffIDs", len(m.Layers), len(c.RootFS.DiffIDs))
 	}
@@ -34,24 +47,32 @@ func (h *Handler) ReadMetadata() (oci.Manifest, oci.Image, error) {
 	return m, c, nil
 }
 
+// ReadIndexAnnotations returns the annotations on the manifest descriptor in
+// index.json
+func (h *Handler) ReadIndexAnnotations() (map[string]string, error) {
+	i, err := h.loadIndex()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load index.json: %s", err.Error())
+	}
+
+	return i.Manifests[0].Annotations, nil
+}
+
 func (h *Handler) loadIndex() (oci.Index, error) {
 	var i oci.Index
 	if _, err := loadJSON(h.indexPath(), &i); err != nil {
 		return oci.Index{}, err
 	}
 
-	if len(i.Manifests) != 1 {
-		return oci.Index{}, fmt.Errorf("invalid # of manifests: expected 1, found %d", len(i.Manifests))
+	/* the first manifest is the image, any others are left as they are */
+	if len(i.Manifests) == 0 {
+		return oci.Index{}, fmt.Errorf("invalid # of manifests: expected at least 1, found 0")
 	}
 
-	if i.Manifests[0].MediaType != oci.MediaTypeImageManifest {
+	if mt := i.Manifests[0].MediaType; mt != oci.MediaTypeImageManifest && mt != dockerManifest {
 		return oci.Index{}, fmt.Errorf("wrong media type for manifest: %s", i.Manifests[0].MediaType)
 	}
 
-	if i.Manifests[0].Platform != nil {
-		return i, validatePlatform(i.Manifests[0].Platform.OS, i.Manifests[0].Platform.Architecture)
-	}
-
 	return i, nil
 }
 
@@ -61,16 +82,16 @@ func (h *Handler) loadManifest(mDesc oci.Descriptor) (oci.Manifest, error) {
 		return oci.Manifest{}, err
 	}
 
-	if m.Config.MediaType != oci.MediaTypeImageConfig {
+	if m.Config.MediaType != oci.MediaTypeImageConfig && m.Config.MediaType != dockerConfig {
 		return oci.Manifest{}, fmt.Errorf("wrong media type for image config: %s", m.Config.MediaType)
 	}
 
 	for _, layer := range m.Layers {
-		if layer.MediaType != oci.MediaTypeImageLayerGzip && layer.MediaType != oci.MediaTypeImageLayer {
+		if !isLayerMediaType(layer.MediaType) {
 			return oci.Manifest{}, fmt.Errorf("invalid layer media type: %s", layer.MediaType)
 		}
 
-		if err := h.validateSHA256(layer); err != nil {
+		if err := h.validateSize(layer); err != nil {
 			return oci.Manifest{}, fmt.Errorf("invalid layer: %s", err.Error())
 		}
 	}
@@ -78,9 +99,21 @@ func (h *Handler) loadManifest(mDesc oci.Descriptor) (oci.Manifest, error) {
 	return m, nil
 }
 
+// loadConfig remembers every field of the config, including docker ones such
+// as container_config that oci.Image does not have, for writeConfig
 func (h *Handler) loadConfig(cDesc oci.Descriptor) (oci.Image, error) {
+	var raw json.RawMessage
+	if err := h.loadDescriptor(cDesc, &raw); err != nil {
+		return oci.Image{}, err
+	}
+
 	var c oci.Image
-	if err := h.loadDescriptor(cDesc, &c); err != nil {
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return oci.Image{}, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
 		return oci.Image{}, err
 	}
 
@@ -88,7 +121,12 @@ func (h *Handler) loadConfig(cDesc oci.Descriptor) (oci.Image, error) {
 		return oci.Image{}, fmt.Errorf("invalid rootfs type: %s", c.RootFS.Type)
 	}
 
-	return c, validatePlatform(c.OS, c.Architecture)
+	if c.OS == "" || c.Architecture == "" {
+		return oci.Image{}, fmt.Errorf("invalid platform: missing os or architecture, found %s/%s", c.OS, c.Architecture)
+	}
+
+	h.configs[cDesc.Digest] = fields
+	return c, nil
 }
 
 func (h *Handler) loadDescriptor(desc oci.Descriptor, obj interface{}) error {
@@ -106,28 +144,31 @@ func (h *Handler) loadDescriptor(desc oci.Descriptor, obj interface{}) error {
 	return nil
 }
 
-func (h *Handler) validateSHA256(d oci.Descriptor) error {
-	expectedSha := d.Digest.Encoded()
-
-	f, err := os.Open(h.blobsPath(expectedSha))
+// validateSize checks a layer without reading it, layers are verified against
+// their digest as they are written by the registry and AddBlob. A size of zero
+// may have been left out of the descriptor, so it is not checked.
+func (h *Handler) validateSize(d oci.Descriptor) error {
+	info, err := os.Stat(h.blobsPath(d.Digest.Encoded()))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	hash := sha256.New()
-	if _, err := io.Copy(hash, f); err != nil {
-		return err
-	}
-
-	sha := fmt.Sprintf("%x", hash.Sum(nil))
-	if sha != expectedSha {
-		return fmt.Errorf("sha256 mismatch: expected %s, found %s", expectedSha, sha)
+	if d.Size > 0 && info.Size() != d.Size {
+		return fmt.Errorf("size mismatch: expected %d bytes, found %d", d.Size, info.Size())
 	}
 
 	return nil
 }
 
+func isLayerMediaType(mediaType string) bool {
+	switch mediaType {
+	case oci.MediaTypeImageLayerGzip, oci.MediaTypeImageLayer, dockerLayer, dockerForeignLayer, ociNonDistributableLayer, ociNonDistributableLayerGzip:
+		return true
+	default:
+		return false
+	}
+}
+
 func loadJSON(file string, obj interface{}) (string, error) {
 	contents, err := os.ReadFile(file)
 	if err != nil {
@@ -137,9 +178,32 @@ func loadJSON(file string, obj interface{}) (string, error) {
 	return fmt.Sprintf("%x", sha256.Sum256(contents)), json.Unmarshal(contents, obj)
 }
 
-func validatePlatform(os string, arch string) error {
-	if os != "windows" || arch != "amd64" {
-		return fmt.Errorf("invalid platform: expected windows/amd64, found %s/%s", os, arch)
+// validatePlatform checks that the platform of the manifest in index.json, if
+// it has one, is the platform of its config. The variant and OS version are
+// often only on one of them, so they are only compared when both have one.
+func validatePlatform(index *oci.Platform, config oci.Platform) error {
+	if index == nil {
+		return nil
+	}
+
+	if index.OS != config.OS || index.Architecture != config.Architecture ||
+		differ(index.Variant, config.Variant) || differ(index.OSVersion, config.OSVersion) {
+		return fmt.Errorf("invalid platform: index.json has %s, image config has %s", platformString(*index), platformString(config))
 	}
 	return nil
 }
+
+func differ(a, b string) bool {
+	return a != "" && b != "" && a != b
+}
+
+func platformString(p oci.Platform) string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	if p.OSVersion != "" {
+		s += " " + p.OSVersion
+	}
+	return s
+}
@@ -60,10 +60,10 @@ var _ = Describe("ReadMetadata", func() {
 		cdesc.MediaType = oci.MediaTypeImageConfig
 
 		layers = []oci.Descriptor{
-			{Digest: writeLayer(srcDir, layer1), MediaType: oci.MediaTypeImageLayerGzip},
-			{Digest: writeLayer(srcDir, layer2), MediaType: oci.MediaTypeImageLayerGzip},
-			{Digest: writeLayer(srcDir, layer3), MediaType: oci.MediaTypeImageLayerGzip},
-			{Digest: writeLayer(srcDir, layer4), MediaType: oci.MediaTypeImageLayer},
+			{Digest: writeLayer(srcDir, layer1), MediaType: oci.MediaTypeImageLayerGzip, Size: int64(len(layer1))},
+			{Digest: writeLayer(srcDir, layer2), MediaType: oci.MediaTypeImageLayerGzip, Size: int64(len(layer2))},
+			{Digest: writeLayer(srcDir, layer3), MediaType: oci.MediaTypeImageLayerGzip, Size: int64(len(layer3))},
+			{Digest: writeLayer(srcDir, layer4), MediaType: oci.MediaTypeImageLayer, Size: int64(len(layer4))},
 		}
 
 		manifest = oci.Manifest{
@@ -93,22 +93,33 @@ var _ = Describe("ReadMetadata", func() {
 		Expect(c).To(Equal(config))
 	})
 
-	Context("# manifests in index.json is not 1", func() {
+	Context("index.json has no manifests", func() {
 		BeforeEach(func() {
-			index = oci.Index{
-				Manifests: []oci.Descriptor{
-					{Digest: digest.Digest("first manifest")},
-					{Digest: digest.Digest("another manifest")},
-				},
-			}
-
-			writeIndex(srcDir, index)
+			writeIndex(srcDir, oci.Index{})
 		})
 
 		It("returns a descriptive error", func() {
 			_, _, err := h.ReadMetadata()
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("invalid # of manifests: expected 1, found 2"))
+			Expect(err.Error()).To(ContainSubstring("invalid # of manifests: expected at least 1, found 0"))
+		})
+	})
+
+	Context("index.json lists other manifests after the image", func() {
+		BeforeEach(func() {
+			index.Manifests = append(index.Manifests, oci.Descriptor{
+				MediaType: oci.MediaTypeImageIndex,
+				Digest:    digest.FromString("another manifest"),
+			})
+			writeIndex(srcDir, index)
+		})
+
+		It("loads the first manifest", func() {
+			m, c, err := h.ReadMetadata()
+			Expect(err).To(Succeed())
+
+			Expect(m).To(Equal(manifest))
+			Expect(c).To(Equal(config))
 		})
 	})
 
@@ -136,7 +147,22 @@ var _ = Describe("ReadMetadata", func() {
 		})
 	})
 
-	Context("manifest in index.json doesn't have os windows", func() {
+	Context("manifest in index.json has the platform of the config", func() {
+		BeforeEach(func() {
+			index.Manifests[0].Platform = &oci.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1234"}
+			writeIndex(srcDir, index)
+		})
+
+		It("loads the manifest and config from disk", func() {
+			m, c, err := h.ReadMetadata()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(m).To(Equal(manifest))
+			Expect(c).To(Equal(config))
+		})
+	})
+
+	Context("manifest in index.json has a different os than the config", func() {
 		BeforeEach(func() {
 			index.Manifests[0].Platform = &oci.Platform{OS: "linux", Architecture: "amd64"}
 			writeIndex(srcDir, index)
@@ -145,11 +171,11 @@ var _ = Describe("ReadMetadata", func() {
 		It("returns a descriptive error", func() {
 			_, _, err := h.ReadMetadata()
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("invalid platform: expected windows/amd64, found linux/amd64"))
+			Expect(err.Error()).To(ContainSubstring("invalid platform: index.json has linux/amd64, image config has windows/amd64"))
 		})
 	})
 
-	Context("manifest in index.json doesn't have arch amd64", func() {
+	Context("manifest in index.json has a different arch than the config", func() {
 		BeforeEach(func() {
 			index.Manifests[0].Platform = &oci.Platform{OS: "windows", Architecture: "some-cpu"}
 			writeIndex(srcDir, index)
@@ -158,20 +184,56 @@ var _ = Describe("ReadMetadata", func() {
 		It("returns a descriptive error", func() {
 			_, _, err := h.ReadMetadata()
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("invalid platform: expected windows/amd64, found windows/some-cpu"))
+			Expect(err.Error()).To(ContainSubstring("invalid platform: index.json has windows/some-cpu, image config has windows/amd64"))
+		})
+	})
+
+	Context("manifest in index.json has a variant and the config does not", func() {
+		BeforeEach(func() {
+			index.Manifests[0].Platform = &oci.Platform{OS: "windows", Architecture: "amd64", Variant: "v3"}
+			writeIndex(srcDir, index)
+		})
+
+		It("loads the manifest and config from disk", func() {
+			_, c, err := h.ReadMetadata()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c).To(Equal(config))
 		})
 	})
 
-	Context("manifest in index.json doesn't have os windows or arch amd64", func() {
+	Context("manifest in index.json has a different variant than the config", func() {
 		BeforeEach(func() {
-			index.Manifests[0].Platform = &oci.Platform{OS: "linux", Architecture: "some-cpu"}
+			config.Variant = "v2"
+			manifest.Config = writeBlob(srcDir, config)
+			manifest.Config.MediaType = oci.MediaTypeImageConfig
+			index.Manifests[0] = writeBlob(srcDir, manifest)
+			index.Manifests[0].MediaType = oci.MediaTypeImageManifest
+			index.Manifests[0].Platform = &oci.Platform{OS: "windows", Architecture: "amd64", Variant: "v3"}
 			writeIndex(srcDir, index)
 		})
 
 		It("returns a descriptive error", func() {
 			_, _, err := h.ReadMetadata()
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("invalid platform: expected windows/amd64, found linux/some-cpu"))
+			Expect(err.Error()).To(ContainSubstring("invalid platform: index.json has windows/amd64/v3, image config has windows/amd64/v2"))
+		})
+	})
+
+	Context("manifest in index.json has a different OS version than the config", func() {
+		BeforeEach(func() {
+			config.OSVersion = "10.0.17763.1577"
+			manifest.Config = writeBlob(srcDir, config)
+			manifest.Config.MediaType = oci.MediaTypeImageConfig
+			index.Manifests[0] = writeBlob(srcDir, manifest)
+			index.Manifests[0].MediaType = oci.MediaTypeImageManifest
+			index.Manifests[0].Platform = &oci.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.169"}
+			writeIndex(srcDir, index)
+		})
+
+		It("returns a descriptive error", func() {
+			_, _, err := h.ReadMetadata()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid platform: index.json has windows/amd64 10.0.20348.169, image config has windows/amd64 10.0.17763.1577"))
 		})
 	})
 
@@ -234,10 +296,40 @@ var _ = Describe("ReadMetadata", func() {
 		})
 	})
 
-	Context("config doesn't have os/arch windows/amd64", func() {
+	Context("config is for another platform", func() {
 		BeforeEach(func() {
-			config.Architecture = "cpu-3"
-			config.OS = "windows"
+			config.OS = "linux"
+			config.Architecture = "arm64"
+			config.Variant = "v8"
+
+			cdesc := writeBlob(srcDir, config)
+			cdesc.MediaType = oci.MediaTypeImageConfig
+
+			manifest.Config = cdesc
+			mdesc := writeBlob(srcDir, manifest)
+			mdesc.MediaType = oci.MediaTypeImageManifest
+			mdesc.Platform = &oci.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+
+			index = oci.Index{
+				Manifests: []oci.Descriptor{mdesc},
+			}
+
+			writeIndex(srcDir, index)
+			h = directory.NewHandler(srcDir)
+		})
+
+		It("loads the manifest and config from disk", func() {
+			m, c, err := h.ReadMetadata()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(m).To(Equal(manifest))
+			Expect(c).To(Equal(config))
+		})
+	})
+
+	Context("config doesn't have an arch", func() {
+		BeforeEach(func() {
+			config.Architecture = ""
 
 			cdesc := writeBlob(srcDir, config)
 			cdesc.MediaType = oci.MediaTypeImageConfig
@@ -259,7 +351,7 @@ var _ = Describe("ReadMetadata", func() {
 		It("returns a descriptive error", func() {
 			_, _, err := h.ReadMetadata()
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("invalid platform: expected windows/amd64, found windows/cpu-3"))
+			Expect(err.Error()).To(ContainSubstring("invalid platform: missing os or architecture, found windows/"))
 		})
 	})
 
@@ -296,9 +388,9 @@ var _ = Describe("ReadMetadata", func() {
 	Context("layers are not all correct media type", func() {
 		BeforeEach(func() {
 			layers = []oci.Descriptor{
-				{Digest: writeLayer(srcDir, layer1), MediaType: "not-a-tar.gz"},
-				{Digest: writeLayer(srcDir, layer2), MediaType: "not-a-tar.gz"},
-				{Digest: writeLayer(srcDir, layer3), MediaType: "not-a-tar.gz"},
+				{Digest: writeLayer(srcDir, layer1), MediaType: "not-a-tar.gz", Size: int64(len(layer1))},
+				{Digest: writeLayer(srcDir, layer2), MediaType: "not-a-tar.gz", Size: int64(len(layer2))},
+				{Digest: writeLayer(srcDir, layer3), MediaType: "not-a-tar.gz", Size: int64(len(layer3))},
 			}
 
 			manifest.Layers = layers
@@ -320,33 +412,51 @@ var _ = Describe("ReadMetadata", func() {
 		})
 	})
 
-	Context("layers do not match sha256", func() {
-		var (
-			originalSha string
-			newSha      string
-		)
+	Context("layers do not match their size", func() {
+		BeforeEach(func() {
+			layerFile := filepath.Join(srcDir, "blobs", "sha256", layers[0].Digest.Encoded())
+			Expect(os.WriteFile(layerFile, []byte("a-different-layer-of-another-size"), 0644)).To(Succeed())
+		})
+
+		It("returns a descriptive error", func() {
+			_, _, err := h.ReadMetadata()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(fmt.Sprintf("invalid layer: size mismatch: expected %d bytes, found %d", len(layer1), len("a-different-layer-of-another-size"))))
+		})
+	})
 
+	Context("a layer descriptor leaves out the size", func() {
 		BeforeEach(func() {
-			originalSha = layers[0].Digest.Encoded()
-			layerFile := filepath.Join(srcDir, "blobs", "sha256", originalSha)
+			manifest.Layers[0].Size = 0
+			index.Manifests[0] = writeBlob(srcDir, manifest)
+			index.Manifests[0].MediaType = oci.MediaTypeImageManifest
+			writeIndex(srcDir, index)
+		})
 
-			newContents := []byte("a-different-layer")
-			newSha = fmt.Sprintf("%x", sha256.Sum256(newContents))
-			Expect(os.WriteFile(layerFile, newContents, 0644)).To(Succeed())
+		It("loads the manifest and config from disk", func() {
+			m, _, err := h.ReadMetadata()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m).To(Equal(manifest))
+		})
+	})
+
+	Context("a layer is missing", func() {
+		BeforeEach(func() {
+			Expect(os.Remove(filepath.Join(srcDir, "blobs", "sha256", layers[1].Digest.Encoded()))).To(Succeed())
 		})
 
 		It("returns a descriptive error", func() {
 			_, _, err := h.ReadMetadata()
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring(fmt.Sprintf("invalid layer: sha256 mismatch: expected %s, found %s", originalSha, newSha)))
+			Expect(err.Error()).To(ContainSubstring("invalid layer"))
 		})
 	})
 
 	Context("number of layers and number of diffids do not match", func() {
 		BeforeEach(func() {
 			layers = []oci.Descriptor{
-				{Digest: writeLayer(srcDir, layer1), MediaType: oci.MediaTypeImageLayerGzip},
-				{Digest: writeLayer(srcDir, layer2), MediaType: oci.MediaTypeImageLayerGzip},
+				{Digest: writeLayer(srcDir, layer1), MediaType: oci.MediaTypeImageLayerGzip, Size: int64(len(layer1))},
+				{Digest: writeLayer(srcDir, layer2), MediaType: oci.MediaTypeImageLayerGzip, Size: int64(len(layer2))},
 			}
 			manifest.Layers = layers
 
@@ -11,27 +11,72 @@ import (
 	oci "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
-func (h *Handler) WriteMetadata(layers []oci.Descriptor, diffIds []digest.Digest, layerAdded bool) error {
+// WriteMetadata writes the config, and the manifest pointing at it. Everything
+// else in the manifest is kept, including its annotations and, if it was read
+// from a layout written by WriteOriginalMetadata, its media types. If
+// manifest.Config is a config that ReadMetadata read, only the rootfs and
+// history of that config are replaced. The index annotations go on the
+// manifest descriptor in index.json.
+func (h *Handler) WriteMetadata(manifest oci.Manifest, config oci.Image, indexAnnotations map[string]string) error {
 	if err := h.writeOCILayout(); err != nil {
 		return err
 	}
 
-	configDescriptor, err := h.writeConfig(diffIds)
+	configDescriptor, err := h.writeConfig(config, manifest.Config)
 	if err != nil {
 		return err
 	}
 
-	annotations := make(map[string]string)
-	/* Mark that the top layer was added using hydrator */
-	if layerAdded {
-		annotations["hydrator.layerAdded"] = "true"
+	manifest.Config = configDescriptor
+	manifestDescriptor, err := h.writeManifest(manifest, config.Platform)
+	if err != nil {
+		return err
+	}
+
+	manifestDescriptor.Annotations = indexAnnotations
+	return h.writeIndexJson(manifestDescriptor)
+}
+
+// WriteOriginalMetadata writes the manifest and config exactly as given, with
+// their original media types, so that the image keeps the digest it has in the
+// registry. The annotations go on the manifest descriptor in index.json.
+func (h *Handler) WriteOriginalMetadata(manifest []byte, config []byte, annotations map[string]string) error {
+	var m oci.Manifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return fmt.Errorf("couldn't parse manifest: %s", err.Error())
+	}
+
+	var c oci.Image
+	if err := json.Unmarshal(config, &c); err != nil {
+		return fmt.Errorf("couldn't parse image config: %s", err.Error())
+	}
+
+	if err := h.writeOCILayout(); err != nil {
+		return err
 	}
 
-	manifestDescriptor, err := h.writeManifest(layers, configDescriptor, annotations)
+	configDescriptor, err := h.writeRawBlob(config)
 	if err != nil {
 		return err
 	}
 
+	if configDescriptor.Digest != m.Config.Digest {
+		return fmt.Errorf("image config does not match the manifest: expected %s, found %s", m.Config.Digest, configDescriptor.Digest)
+	}
+
+	manifestDescriptor, err := h.writeRawBlob(manifest)
+	if err != nil {
+		return err
+	}
+
+	/* mediaType is optional in OCI manifests, and always set in docker ones */
+	manifestDescriptor.MediaType = m.MediaType
+	if manifestDescriptor.MediaType == "" {
+		manifestDescriptor.MediaType = oci.MediaTypeImageManifest
+	}
+	manifestDescriptor.Platform = indexPlatform(c.Platform)
+	manifestDescriptor.Annotations = annotations
+
 	return h.writeIndexJson(manifestDescriptor)
 }
 
@@ -47,48 +92,112 @@ func (h *Handler) writeOCILayout() error {
 	return os.WriteFile(h.ociLayoutPath(), data, 0644)
 }
 
-func (h *Handler) writeConfig(diffIds []digest.Digest) (oci.Descriptor, error) {
-	ic := oci.Image{
-		Platform: oci.Platform{
-			Architecture: "amd64",
-			OS:           "windows",
-		},
-		RootFS: oci.RootFS{Type: "layers", DiffIDs: diffIds},
+// writeConfig keeps everything in the config, such as its environment and
+// history. A docker image config is a valid OCI image config, so only the
+// media type of its descriptor changes.
+func (h *Handler) writeConfig(config oci.Image, previous oci.Descriptor) (oci.Descriptor, error) {
+	config.RootFS.Type = "layers"
+
+	var data []byte
+	var err error
+	if fields, ok := h.configs[previous.Digest]; ok {
+		data, err = mergeConfig(fields, config)
+	} else {
+		data, err = json.Marshal(config)
+	}
+	if err != nil {
+		return oci.Descriptor{}, err
 	}
 
-	d, err := h.writeBlob(ic)
+	d, err := h.writeRawBlob(data)
 	if err != nil {
 		return oci.Descriptor{}, err
 	}
 
-	d.MediaType = oci.MediaTypeImageConfig
+	d.MediaType = previous.MediaType
+	if d.MediaType == "" {
+		d.MediaType = oci.MediaTypeImageConfig
+	}
+	d.Annotations = previous.Annotations
 	return d, nil
 }
 
-func (h *Handler) writeManifest(layers []oci.Descriptor, config oci.Descriptor, annotations map[string]string) (oci.Descriptor, error) {
-	im := oci.Manifest{
-		Versioned:   specs.Versioned{SchemaVersion: 2},
-		Config:      config,
-		Layers:      layers,
-		Annotations: annotations,
+// mergeConfig replaces the rootfs type and diff_ids and the history of the
+// config fields, so that fields oci.Image does not have round-trip unchanged
+func mergeConfig(fields map[string]json.RawMessage, config oci.Image) ([]byte, error) {
+	merged := make(map[string]json.RawMessage, len(fields)+2)
+	for k, v := range fields {
+		merged[k] = v
+	}
+
+	rootfs := map[string]json.RawMessage{}
+	if r, ok := fields["rootfs"]; ok {
+		if err := json.Unmarshal(r, &rootfs); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, value := range map[string]interface{}{"type": config.RootFS.Type, "diff_ids": config.RootFS.DiffIDs} {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		rootfs[key] = data
+	}
+
+	data, err := json.Marshal(rootfs)
+	if err != nil {
+		return nil, err
+	}
+	merged["rootfs"] = data
+
+	if _, ok := fields["history"]; ok || len(config.History) > 0 {
+		data, err := json.Marshal(config.History)
+		if err != nil {
+			return nil, err
+		}
+		merged["history"] = data
 	}
 
-	d, err := h.writeBlob(im)
+	return json.Marshal(merged)
+}
+
+func (h *Handler) writeManifest(manifest oci.Manifest, platform oci.Platform) (oci.Descriptor, error) {
+	manifest.Versioned = specs.Versioned{SchemaVersion: 2}
+
+	d, err := h.writeBlob(manifest)
 	if err != nil {
 		return oci.Descriptor{}, err
 	}
 
-	d.MediaType = oci.MediaTypeImageManifest
-	d.Platform = &oci.Platform{OS: "windows", Architecture: "amd64"}
+	d.MediaType = manifest.MediaType
+	if d.MediaType == "" {
+		d.MediaType = oci.MediaTypeImageManifest
+	}
+	d.Platform = indexPlatform(platform)
 	return d, nil
 }
 
+// indexPlatform leaves out os.features, which only describe the config
+func indexPlatform(platform oci.Platform) *oci.Platform {
+	return &oci.Platform{
+		OS:           platform.OS,
+		Architecture: platform.Architecture,
+		Variant:      platform.Variant,
+		OSVersion:    platform.OSVersion,
+	}
+}
+
 func (h *Handler) writeBlob(blob interface{}) (oci.Descriptor, error) {
 	data, err := json.Marshal(blob)
 	if err != nil {
 		return oci.Descriptor{}, err
 	}
 
+	return h.writeRawBlob(data)
+}
+
+func (h *Handler) writeRawBlob(data []byte) (oci.Descriptor, error) {
 	if err := os.MkdirAll(h.blobsDir(), 0755); err != nil {
 		return oci.Descriptor{}, err
 	}
@@ -106,10 +215,25 @@ func (h *Handler) writeBlob(blob interface{}) (oci.Descriptor, error) {
 	}, nil
 }
 
+// writeIndexJson puts the manifest in place of the first one in index.json,
+// or in the index.json that ClearMetadata removed, keeping everything else
 func (h *Handler) writeIndexJson(manifest oci.Descriptor) error {
-	ii := oci.Index{
-		Versioned: specs.Versioned{SchemaVersion: 2},
-		Manifests: []oci.Descriptor{manifest},
+	var ii oci.Index
+	if _, err := loadJSON(h.indexPath(), &ii); err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("couldn't load index.json: %s", err.Error())
+		}
+		if h.clearedIndex != nil {
+			ii = *h.clearedIndex
+		}
+	}
+	h.clearedIndex = nil
+
+	ii.Versioned = specs.Versioned{SchemaVersion: 2}
+	if len(ii.Manifests) == 0 {
+		ii.Manifests = []oci.Descriptor{manifest}
+	} else {
+		ii.Manifests[0] = manifest
 	}
 
 	data, err := json.Marshal(ii)
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	directory "code.cloudfoundry.org/hydrator/oci-directory"
 	. "github.com/onsi/ginkgo/v2"
@@ -18,11 +19,13 @@ import (
 
 var _ = Describe("WriteMetadata", func() {
 	var (
-		h          *directory.Handler
-		layers     []oci.Descriptor
-		diffIds    []digest.Digest
-		layerAdded bool
-		outDir     string
+		h                *directory.Handler
+		layers           []oci.Descriptor
+		manifest         oci.Manifest
+		diffIds          []digest.Digest
+		config           oci.Image
+		indexAnnotations map[string]string
+		outDir           string
 	)
 
 	BeforeEach(func() {
@@ -37,7 +40,14 @@ var _ = Describe("WriteMetadata", func() {
 
 		diffIds = []digest.Digest{digest.NewDigestFromEncoded(digest.SHA256, "aaaaaa"), digest.NewDigestFromEncoded(digest.SHA256, "bbbbbb")}
 
-		layerAdded = false
+		config = oci.Image{
+			Platform: oci.Platform{OS: "windows", Architecture: "amd64"},
+			RootFS:   oci.RootFS{Type: "layers", DiffIDs: diffIds},
+		}
+
+		indexAnnotations = nil
+
+		manifest = oci.Manifest{Layers: layers}
 
 		h = directory.NewHandler(outDir)
 	})
@@ -47,7 +57,7 @@ var _ = Describe("WriteMetadata", func() {
 	})
 
 	It("writes a valid oci layout file", func() {
-		Expect(h.WriteMetadata(layers, diffIds, layerAdded)).To(Succeed())
+		Expect(h.WriteMetadata(manifest, config, indexAnnotations)).To(Succeed())
 
 		var il oci.ImageLayout
 		content, err := os.ReadFile(filepath.Join(outDir, "oci-layout"))
@@ -57,7 +67,7 @@ var _ = Describe("WriteMetadata", func() {
 	})
 
 	It("writes a valid index.json file", func() {
-		Expect(h.WriteMetadata(layers, diffIds, layerAdded)).To(Succeed())
+		Expect(h.WriteMetadata(manifest, config, indexAnnotations)).To(Succeed())
 
 		ii := loadIndex(outDir)
 		Expect(ii.SchemaVersion).To(Equal(2))
@@ -80,14 +90,14 @@ var _ = Describe("WriteMetadata", func() {
 	})
 
 	Context("When writing a valid manifest file", func() {
-		It("generates an image config and sets the layerAdded annotation to false", func() {
-			Expect(h.WriteMetadata(layers, diffIds, layerAdded)).To(Succeed())
+		It("generates an image config without annotations", func() {
+			Expect(h.WriteMetadata(manifest, config, indexAnnotations)).To(Succeed())
 
 			im := loadManifest(outDir)
 
 			Expect(im.Layers).To(ConsistOf(layers))
 			Expect(im.SchemaVersion).To(Equal(2))
-			Expect(im.Annotations).NotTo(HaveKey("hydrator.layerAdded"))
+			Expect(im.Annotations).To(BeEmpty())
 
 			configFile := filepath.Join(outDir, "blobs", im.Config.Digest.Algorithm().String(), im.Config.Digest.Encoded())
 			fi, err := os.Stat(configFile)
@@ -96,9 +106,9 @@ var _ = Describe("WriteMetadata", func() {
 			Expect(sha256Sum(configFile)).To(Equal(im.Config.Digest.Encoded()))
 		})
 
-		It("generates an image config and sets the layerAdded annotation to true", func() {
-			layerAdded = true
-			Expect(h.WriteMetadata(layers, diffIds, layerAdded)).To(Succeed())
+		It("generates an image config and keeps the manifest annotations", func() {
+			manifest.Annotations = map[string]string{"hydrator.layerAdded": "true"}
+			Expect(h.WriteMetadata(manifest, config, indexAnnotations)).To(Succeed())
 
 			im := loadManifest(outDir)
 
@@ -115,7 +125,7 @@ var _ = Describe("WriteMetadata", func() {
 	})
 
 	It("writes a valid image config file", func() {
-		Expect(h.WriteMetadata(layers, diffIds, layerAdded)).To(Succeed())
+		Expect(h.WriteMetadata(manifest, config, indexAnnotations)).To(Succeed())
 
 		ic := loadConfig(outDir)
 
@@ -124,6 +134,262 @@ var _ = Describe("WriteMetadata", func() {
 		expectedRootFS := oci.RootFS{Type: "layers", DiffIDs: diffIds}
 		Expect(ic.RootFS).To(Equal(expectedRootFS))
 	})
+
+	It("puts the index annotations on the manifest descriptor", func() {
+		indexAnnotations = map[string]string{"some-key": "some-value"}
+		Expect(h.WriteMetadata(manifest, config, indexAnnotations)).To(Succeed())
+
+		ii := loadIndex(outDir)
+		Expect(ii.Manifests[0].Annotations).To(Equal(indexAnnotations))
+
+		readAnnotations, err := h.ReadIndexAnnotations()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(readAnnotations).To(Equal(indexAnnotations))
+	})
+
+	Context("index.json already lists several manifests", func() {
+		var other oci.Descriptor
+
+		BeforeEach(func() {
+			other = oci.Descriptor{MediaType: oci.MediaTypeImageManifest, Digest: digest.FromString("other-manifest"), Size: 14}
+			writeIndex(outDir, oci.Index{Manifests: []oci.Descriptor{
+				{MediaType: oci.MediaTypeImageManifest, Digest: digest.FromString("old-manifest"), Size: 12},
+				other,
+			}})
+		})
+
+		It("replaces the first manifest and keeps the others", func() {
+			Expect(h.WriteMetadata(manifest, config, indexAnnotations)).To(Succeed())
+
+			ii := loadIndex(outDir)
+			Expect(ii.Manifests).To(HaveLen(2))
+			Expect(ii.Manifests[0].Digest).NotTo(Equal(digest.FromString("old-manifest")))
+			Expect(filepath.Join(outDir, "blobs", "sha256", ii.Manifests[0].Digest.Encoded())).To(BeAnExistingFile())
+			Expect(ii.Manifests[1]).To(Equal(other))
+		})
+	})
+
+	Context("the manifest has docker media types", func() {
+		BeforeEach(func() {
+			manifest.MediaType = "application/vnd.docker.distribution.manifest.v2+json"
+			manifest.Config = oci.Descriptor{
+				MediaType:   "application/vnd.docker.container.image.v1+json",
+				Digest:      "sha256:some-old-config",
+				Size:        1,
+				Annotations: map[string]string{"some-key": "some-value"},
+			}
+		})
+
+		It("keeps them", func() {
+			Expect(h.WriteMetadata(manifest, config, indexAnnotations)).To(Succeed())
+
+			ii := loadIndex(outDir)
+			Expect(ii.Manifests[0].MediaType).To(Equal("application/vnd.docker.distribution.manifest.v2+json"))
+
+			im := loadManifest(outDir)
+			Expect(im.MediaType).To(Equal("application/vnd.docker.distribution.manifest.v2+json"))
+			Expect(im.Config.MediaType).To(Equal("application/vnd.docker.container.image.v1+json"))
+			Expect(im.Config.Annotations).To(HaveKeyWithValue("some-key", "some-value"))
+			Expect(im.Config.Digest).NotTo(Equal(digest.Digest("sha256:some-old-config")))
+		})
+	})
+
+	Context("the config has more than a platform and rootfs", func() {
+		BeforeEach(func() {
+			created := time.Date(2020, 11, 10, 12, 0, 0, 0, time.UTC)
+			config.Created = &created
+			config.Author = "someone"
+			config.OSVersion = "10.0.17763.1577"
+			config.Config = oci.ImageConfig{
+				User:       "ContainerUser",
+				Env:        []string{"PATH=C:\\Windows\\system32;C:\\Windows", "FOO=bar"},
+				Entrypoint: []string{"cmd", "/S", "/C"},
+				Cmd:        []string{"c:\\windows\\system32\\cmd.exe"},
+				WorkingDir: "C:\\",
+				Labels:     map[string]string{"some-label": "some-value"},
+			}
+			config.History = []oci.History{
+				{Created: &created, CreatedBy: "Apply image 10.0.17763.1577"},
+				{Created: &created, CreatedBy: "Install update 10.0.17763.1577"},
+			}
+		})
+
+		It("writes all of it to the image config", func() {
+			Expect(h.WriteMetadata(manifest, config, indexAnnotations)).To(Succeed())
+
+			ic := loadConfig(outDir)
+			Expect(ic).To(Equal(config))
+
+			ii := loadIndex(outDir)
+			Expect(ii.Manifests[0].Platform.OSVersion).To(Equal("10.0.17763.1577"))
+		})
+	})
+
+	Context("the config was read from a layout and has docker fields", func() {
+		const dockerConfig = `{
+			"architecture": "amd64",
+			"os": "windows",
+			"os.version": "10.0.17763.1577",
+			"config": {"Hostname": "", "Domainname": "some-domain", "Env": ["FOO=bar"]},
+			"container": "0123456789ab",
+			"container_config": {"Hostname": "0123456789ab", "Cmd": ["cmd", "/S", "/C", "#(nop) ENV FOO=bar"]},
+			"docker_version": "20.10.7",
+			"rootfs": {"type": "layers", "diff_ids": ["sha256:aaaaaa"]},
+			"history": [{"created_by": "Apply image 10.0.17763.1577"}]
+		}`
+
+		BeforeEach(func() {
+			layer := "some-layer"
+			configFile := filepath.Join(outDir, "blobs", "sha256", fmt.Sprintf("%x", sha256.Sum256([]byte(dockerConfig))))
+			Expect(os.MkdirAll(filepath.Dir(configFile), 0755)).To(Succeed())
+			Expect(os.WriteFile(configFile, []byte(dockerConfig), 0644)).To(Succeed())
+
+			mdesc := writeBlob(outDir, oci.Manifest{
+				Config: oci.Descriptor{
+					MediaType: "application/vnd.docker.container.image.v1+json",
+					Digest:    digest.FromString(dockerConfig),
+					Size:      int64(len(dockerConfig)),
+				},
+				Layers: []oci.Descriptor{
+					{MediaType: oci.MediaTypeImageLayerGzip, Digest: writeLayer(outDir, layer), Size: int64(len(layer))},
+				},
+			})
+			mdesc.MediaType = oci.MediaTypeImageManifest
+			writeIndex(outDir, oci.Index{Manifests: []oci.Descriptor{mdesc}})
+		})
+
+		It("only replaces the rootfs and history", func() {
+			m, c, err := h.ReadMetadata()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(h.ClearMetadata()).To(Succeed())
+
+			c.RootFS.DiffIDs = append(c.RootFS.DiffIDs, digest.NewDigestFromEncoded(digest.SHA256, "bbbbbb"))
+			c.History = append(c.History, oci.History{CreatedBy: "hydrate add-layer some-layer.tgz"})
+			Expect(h.WriteMetadata(m, c, nil)).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(outDir, "blobs", "sha256", loadManifest(outDir).Config.Digest.Encoded()))
+			Expect(err).NotTo(HaveOccurred())
+
+			var written, original map[string]interface{}
+			Expect(json.Unmarshal(content, &written)).To(Succeed())
+			Expect(json.Unmarshal([]byte(dockerConfig), &original)).To(Succeed())
+
+			Expect(written["rootfs"]).To(Equal(map[string]interface{}{"type": "layers", "diff_ids": []interface{}{"sha256:aaaaaa", "sha256:bbbbbb"}}))
+			Expect(written["history"]).To(Equal([]interface{}{
+				map[string]interface{}{"created_by": "Apply image 10.0.17763.1577"},
+				map[string]interface{}{"created_by": "hydrate add-layer some-layer.tgz"},
+			}))
+
+			delete(written, "rootfs")
+			delete(written, "history")
+			delete(original, "rootfs")
+			delete(original, "history")
+			Expect(written).To(Equal(original))
+		})
+	})
+
+	Context("the config is for another platform", func() {
+		BeforeEach(func() {
+			config.Platform = oci.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+		})
+
+		It("writes the platform to the config and the manifest descriptor", func() {
+			Expect(h.WriteMetadata(manifest, config, indexAnnotations)).To(Succeed())
+
+			ic := loadConfig(outDir)
+			Expect(ic.Platform).To(Equal(oci.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}))
+
+			ii := loadIndex(outDir)
+			Expect(*ii.Manifests[0].Platform).To(Equal(oci.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}))
+		})
+	})
+})
+
+var _ = Describe("WriteOriginalMetadata", func() {
+	const layer = "some-layer"
+
+	var (
+		h           *directory.Handler
+		outDir      string
+		config      []byte
+		manifest    []byte
+		annotations map[string]string
+	)
+
+	BeforeEach(func() {
+		var err error
+		outDir, err = os.MkdirTemp("", "oci-directory.write.original.test")
+		Expect(err).NotTo(HaveOccurred())
+
+		layerDigest := writeLayer(outDir, layer)
+		diffId := digest.FromString("some-diff-id")
+
+		/* formatted differently to how encoding/json would write it */
+		config = []byte(fmt.Sprintf(`{"architecture": "amd64", "os": "windows", "os.version": "10.0.17763.1577",
+  "config": {"Env": ["FOO=bar"]}, "rootfs": {"type": "layers", "diff_ids": ["%s"]}}`, diffId))
+
+		manifest = []byte(fmt.Sprintf(`{
+   "schemaVersion": 2,
+   "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+   "config": {"mediaType": "application/vnd.docker.container.image.v1+json", "size": %d, "digest": "%s"},
+   "layers": [{"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip", "size": %d, "digest": "%s"}]
+}`, len(config), digest.FromBytes(config), len(layer), layerDigest))
+
+		annotations = map[string]string{"hydrator.sourceImage": "some-image"}
+
+		h = directory.NewHandler(outDir)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(outDir)).To(Succeed())
+	})
+
+	It("writes the manifest and config byte for byte", func() {
+		Expect(h.WriteOriginalMetadata(manifest, config, annotations)).To(Succeed())
+
+		content, err := os.ReadFile(filepath.Join(outDir, "blobs", "sha256", digest.FromBytes(manifest).Encoded()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(content).To(Equal(manifest))
+
+		content, err = os.ReadFile(filepath.Join(outDir, "blobs", "sha256", digest.FromBytes(config).Encoded()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(content).To(Equal(config))
+
+		Expect(filepath.Join(outDir, "oci-layout")).To(BeAnExistingFile())
+	})
+
+	It("points index.json at the manifest with its original media type", func() {
+		Expect(h.WriteOriginalMetadata(manifest, config, annotations)).To(Succeed())
+
+		ii := loadIndex(outDir)
+		Expect(ii.Manifests).To(HaveLen(1))
+		Expect(ii.Manifests[0].Digest).To(Equal(digest.FromBytes(manifest)))
+		Expect(ii.Manifests[0].Size).To(Equal(int64(len(manifest))))
+		Expect(ii.Manifests[0].MediaType).To(Equal("application/vnd.docker.distribution.manifest.v2+json"))
+		Expect(*ii.Manifests[0].Platform).To(Equal(oci.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1577"}))
+		Expect(ii.Manifests[0].Annotations).To(Equal(annotations))
+	})
+
+	It("writes a layout that can be read", func() {
+		Expect(h.WriteOriginalMetadata(manifest, config, annotations)).To(Succeed())
+
+		m, c, err := h.ReadMetadata()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Layers).To(HaveLen(1))
+		Expect(m.Layers[0].MediaType).To(Equal("application/vnd.docker.image.rootfs.diff.tar.gzip"))
+		Expect(c.Config.Env).To(Equal([]string{"FOO=bar"}))
+	})
+
+	Context("the config is not the one in the manifest", func() {
+		BeforeEach(func() {
+			config = []byte(`{"architecture": "amd64", "os": "windows", "rootfs": {"type": "layers"}}`)
+		})
+
+		It("returns a descriptive error", func() {
+			err := h.WriteOriginalMetadata(manifest, config, annotations)
+			Expect(err).To(MatchError(ContainSubstring("image config does not match the manifest")))
+		})
+	})
 })
 
 func loadIndex(outDir string) oci.Index {
@@ -0,0 +1,147 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+const (
+	barWidth          = 30
+	barRedrawInterval = 100 * time.Millisecond
+)
+
+// Bars draws a progress bar per blob using ANSI escape sequences. It is also
+// an io.Writer, so that log lines written through it appear above the bars
+// instead of breaking them up.
+type Bars struct {
+	mutex      sync.Mutex
+	out        io.Writer
+	blobs      []*bar
+	drawnLines int
+	lastDraw   time.Time
+}
+
+type bar struct {
+	blob   digest.Digest
+	total  int64
+	done   int64
+	status string
+}
+
+func NewBars(out io.Writer) *Bars {
+	return &Bars{out: out}
+}
+
+func (b *Bars) Start(blob digest.Digest, total int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if l := b.find(blob); l != nil {
+		l.total, l.done, l.status = total, 0, ""
+	} else {
+		b.blobs = append(b.blobs, &bar{blob: blob, total: total})
+	}
+	b.redraw()
+}
+
+func (b *Bars) Update(blob digest.Digest, done int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	l := b.find(blob)
+	if l == nil {
+		return
+	}
+	l.done = done
+
+	if time.Since(b.lastDraw) >= barRedrawInterval {
+		b.redraw()
+	}
+}
+
+func (b *Bars) Finish(blob digest.Digest, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	l := b.find(blob)
+	if l == nil {
+		return
+	}
+
+	l.status = "done"
+	if err != nil {
+		l.status = "failed"
+	}
+	b.redraw()
+}
+
+func (b *Bars) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.clear()
+	n, err := b.out.Write(p)
+	b.redraw()
+	return n, err
+}
+
+func (b *Bars) find(blob digest.Digest) *bar {
+	for _, l := range b.blobs {
+		if l.blob == blob {
+			return l
+		}
+	}
+	return nil
+}
+
+// clear moves the cursor back up to the first bar and erases everything below it
+func (b *Bars) clear() {
+	if b.drawnLines > 0 {
+		fmt.Fprintf(b.out, "\x1b[%dA\x1b[J", b.drawnLines)
+		b.drawnLines = 0
+	}
+}
+
+func (b *Bars) redraw() {
+	b.clear()
+	for _, l := range b.blobs {
+		fmt.Fprintln(b.out, l.String())
+	}
+	b.drawnLines = len(b.blobs)
+	b.lastDraw = time.Now()
+}
+
+func (l *bar) String() string {
+	name := l.blob.Encoded()
+	if len(name) > 12 {
+		name = name[:12]
+	}
+
+	if l.status != "" {
+		return fmt.Sprintf("%s %s %s", name, l.status, humanSize(l.done))
+	}
+
+	if l.total <= 0 {
+		return fmt.Sprintf("%s %s", name, humanSize(l.done))
+	}
+
+	done := l.done
+	if done > l.total {
+		done = l.total
+	}
+	filled := int(done * barWidth / l.total)
+
+	return fmt.Sprintf("%s [%s%s] %3d%% %s/%s",
+		name,
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", barWidth-filled),
+		done*100/l.total,
+		humanSize(l.done),
+		humanSize(l.total),
+	)
+}
@@ -0,0 +1,67 @@
+package progress_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"code.cloudfoundry.org/hydrator/progress"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	digest "github.com/opencontainers/go-digest"
+)
+
+var _ = Describe("Bars", func() {
+	var (
+		out   *bytes.Buffer
+		bars  *progress.Bars
+		blob1 digest.Digest
+		blob2 digest.Digest
+	)
+
+	BeforeEach(func() {
+		out = new(bytes.Buffer)
+		bars = progress.NewBars(out)
+		blob1 = digest.NewDigestFromEncoded(digest.SHA256, strings.Repeat("a", 64))
+		blob2 = digest.NewDigestFromEncoded(digest.SHA256, strings.Repeat("b", 64))
+	})
+
+	It("draws a bar for each blob", func() {
+		bars.Start(blob1, 2000000)
+		bars.Start(blob2, 1000)
+
+		Expect(out.String()).To(HaveSuffix("\x1b[1A\x1b[J" +
+			"aaaaaaaaaaaa [                              ]   0% 0B/2.0MB\n" +
+			"bbbbbbbbbbbb [                              ]   0% 0B/1.0kB\n"))
+	})
+
+	It("shows the final state of each blob", func() {
+		bars.Start(blob1, 2000000)
+		bars.Start(blob2, 1000)
+		bars.Update(blob1, 1000000)
+		bars.Finish(blob1, nil)
+		bars.Finish(blob2, errors.New("not found"))
+
+		Expect(out.String()).To(HaveSuffix("\x1b[2A\x1b[J" +
+			"aaaaaaaaaaaa done 1.0MB\n" +
+			"bbbbbbbbbbbb failed 0B\n"))
+	})
+
+	It("draws a partly downloaded blob", func() {
+		bars.Start(blob1, 2000000)
+		Eventually(func() string {
+			bars.Update(blob1, 500000)
+			return out.String()
+		}).Should(HaveSuffix("aaaaaaaaaaaa [=======                       ]  25% 500.0kB/2.0MB\n"))
+	})
+
+	It("writes log lines above the bars", func() {
+		bars.Start(blob1, 1000)
+		_, err := bars.Write([]byte("some log line\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(out.String()).To(HaveSuffix("\x1b[1A\x1b[J" +
+			"some log line\n" +
+			"aaaaaaaaaaaa [                              ]   0% 0B/1.0kB\n"))
+	})
+})
@@ -0,0 +1,155 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/hydrator/progress"
+	digest "github.com/opencontainers/go-digest"
+)
+
+type Reporter struct {
+	FinishStub        func(digest.Digest, error)
+	finishMutex       sync.RWMutex
+	finishArgsForCall []struct {
+		arg1 digest.Digest
+		arg2 error
+	}
+	StartStub        func(digest.Digest, int64)
+	startMutex       sync.RWMutex
+	startArgsForCall []struct {
+		arg1 digest.Digest
+		arg2 int64
+	}
+	UpdateStub        func(digest.Digest, int64)
+	updateMutex       sync.RWMutex
+	updateArgsForCall []struct {
+		arg1 digest.Digest
+		arg2 int64
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *Reporter) Finish(arg1 digest.Digest, arg2 error) {
+	fake.finishMutex.Lock()
+	fake.finishArgsForCall = append(fake.finishArgsForCall, struct {
+		arg1 digest.Digest
+		arg2 error
+	}{arg1, arg2})
+	stub := fake.FinishStub
+	fake.recordInvocation("Finish", []interface{}{arg1, arg2})
+	fake.finishMutex.Unlock()
+	if stub != nil {
+		fake.FinishStub(arg1, arg2)
+	}
+}
+
+func (fake *Reporter) FinishCallCount() int {
+	fake.finishMutex.RLock()
+	defer fake.finishMutex.RUnlock()
+	return len(fake.finishArgsForCall)
+}
+
+func (fake *Reporter) FinishCalls(stub func(digest.Digest, error)) {
+	fake.finishMutex.Lock()
+	defer fake.finishMutex.Unlock()
+	fake.FinishStub = stub
+}
+
+func (fake *Reporter) FinishArgsForCall(i int) (digest.Digest, error) {
+	fake.finishMutex.RLock()
+	defer fake.finishMutex.RUnlock()
+	argsForCall := fake.finishArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *Reporter) Start(arg1 digest.Digest, arg2 int64) {
+	fake.startMutex.Lock()
+	fake.startArgsForCall = append(fake.startArgsForCall, struct {
+		arg1 digest.Digest
+		arg2 int64
+	}{arg1, arg2})
+	stub := fake.StartStub
+	fake.recordInvocation("Start", []interface{}{arg1, arg2})
+	fake.startMutex.Unlock()
+	if stub != nil {
+		fake.StartStub(arg1, arg2)
+	}
+}
+
+func (fake *Reporter) StartCallCount() int {
+	fake.startMutex.RLock()
+	defer fake.startMutex.RUnlock()
+	return len(fake.startArgsForCall)
+}
+
+func (fake *Reporter) StartCalls(stub func(digest.Digest, int64)) {
+	fake.startMutex.Lock()
+	defer fake.startMutex.Unlock()
+	fake.StartStub = stub
+}
+
+func (fake *Reporter) StartArgsForCall(i int) (digest.Digest, int64) {
+	fake.startMutex.RLock()
+	defer fake.startMutex.RUnlock()
+	argsForCall := fake.startArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *Reporter) Update(arg1 digest.Digest, arg2 int64) {
+	fake.updateMutex.Lock()
+	fake.updateArgsForCall = append(fake.updateArgsForCall, struct {
+		arg1 digest.Digest
+		arg2 int64
+	}{arg1, arg2})
+	stub := fake.UpdateStub
+	fake.recordInvocation("Update", []interface{}{arg1, arg2})
+	fake.updateMutex.Unlock()
+	if stub != nil {
+		fake.UpdateStub(arg1, arg2)
+	}
+}
+
+func (fake *Reporter) UpdateCallCount() int {
+	fake.updateMutex.RLock()
+	defer fake.updateMutex.RUnlock()
+	return len(fake.updateArgsForCall)
+}
+
+func (fake *Reporter) UpdateCalls(stub func(digest.Digest, int64)) {
+	fake.updateMutex.Lock()
+	defer fake.updateMutex.Unlock()
+	fake.UpdateStub = stub
+}
+
+func (fake *Reporter) UpdateArgsForCall(i int) (digest.Digest, int64) {
+	fake.updateMutex.RLock()
+	defer fake.updateMutex.RUnlock()
+	argsForCall := fake.updateArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *Reporter) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *Reporter) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ progress.Reporter = new(Reporter)
@@ -0,0 +1,85 @@
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+const jsonUpdateInterval = 500 * time.Millisecond
+
+// Event is written as one line of JSON for each start, update and finish
+type Event struct {
+	Event  string `json:"event"`
+	Digest string `json:"digest"`
+	Done   int64  `json:"done"`
+	Total  int64  `json:"total"`
+	Error  string `json:"error,omitempty"`
+}
+
+// JSON writes newline delimited Events, with at most one update per blob
+// every half second
+type JSON struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	blobs   map[digest.Digest]*jsonBlob
+}
+
+type jsonBlob struct {
+	total      int64
+	done       int64
+	lastUpdate time.Time
+}
+
+func NewJSON(w io.Writer) *JSON {
+	return &JSON{
+		encoder: json.NewEncoder(w),
+		blobs:   make(map[digest.Digest]*jsonBlob),
+	}
+}
+
+func (j *JSON) Start(blob digest.Digest, total int64) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.blobs[blob] = &jsonBlob{total: total}
+	j.encoder.Encode(Event{Event: "start", Digest: blob.String(), Total: total})
+}
+
+func (j *JSON) Update(blob digest.Digest, done int64) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	b, ok := j.blobs[blob]
+	if !ok {
+		return
+	}
+
+	b.done = done
+	if time.Since(b.lastUpdate) < jsonUpdateInterval {
+		return
+	}
+	b.lastUpdate = time.Now()
+
+	j.encoder.Encode(Event{Event: "update", Digest: blob.String(), Done: done, Total: b.total})
+}
+
+func (j *JSON) Finish(blob digest.Digest, err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	b, ok := j.blobs[blob]
+	if !ok {
+		return
+	}
+	delete(j.blobs, blob)
+
+	e := Event{Event: "finish", Digest: blob.String(), Done: b.done, Total: b.total}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	j.encoder.Encode(e)
+}
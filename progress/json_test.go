@@ -0,0 +1,64 @@
+package progress_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"code.cloudfoundry.org/hydrator/progress"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	digest "github.com/opencontainers/go-digest"
+)
+
+var _ = Describe("JSON", func() {
+	var (
+		out      *bytes.Buffer
+		reporter *progress.JSON
+		blob     digest.Digest
+	)
+
+	BeforeEach(func() {
+		out = new(bytes.Buffer)
+		reporter = progress.NewJSON(out)
+		blob = digest.NewDigestFromEncoded(digest.SHA256, strings.Repeat("a", 64))
+	})
+
+	events := func() []progress.Event {
+		var events []progress.Event
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			var e progress.Event
+			Expect(json.Unmarshal([]byte(line), &e)).To(Succeed())
+			events = append(events, e)
+		}
+		return events
+	}
+
+	It("writes an event per line for the start, updates and finish of a blob", func() {
+		reporter.Start(blob, 1000)
+		reporter.Update(blob, 100)
+		reporter.Update(blob, 500)
+		reporter.Update(blob, 1000)
+		reporter.Finish(blob, nil)
+
+		Expect(events()).To(Equal([]progress.Event{
+			{Event: "start", Digest: blob.String(), Total: 1000},
+			{Event: "update", Digest: blob.String(), Done: 100, Total: 1000},
+			{Event: "finish", Digest: blob.String(), Done: 1000, Total: 1000},
+		}))
+	})
+
+	It("includes the error when a blob fails", func() {
+		reporter.Start(blob, 1000)
+		reporter.Finish(blob, errors.New("not found"))
+
+		Expect(events()[1]).To(Equal(progress.Event{Event: "finish", Digest: blob.String(), Total: 1000, Error: "not found"}))
+	})
+
+	It("ignores blobs that were not started", func() {
+		reporter.Update(blob, 100)
+		reporter.Finish(blob, nil)
+		Expect(out.String()).To(BeEmpty())
+	})
+})
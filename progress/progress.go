@@ -0,0 +1,37 @@
+package progress
+
+import (
+	"fmt"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+// Reporter is told about the progress of each blob download. The downloader
+// calls Start and Finish, and the registry calls Update while writing the
+// blob. Implementations must be safe for concurrent use.
+//
+//go:generate counterfeiter -o fakes/reporter.go --fake-name Reporter . Reporter
+type Reporter interface {
+	Start(blob digest.Digest, total int64)
+	// Update reports the bytes written so far. A retried download carries on
+	// from where it stopped, or starts again from zero if it cannot resume.
+	Update(blob digest.Digest, done int64)
+	// Finish is called once the blob is downloaded, or has failed for good
+	Finish(blob digest.Digest, err error)
+}
+
+func humanSize(bytes int64) string {
+	const unit = 1000
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+
+	size := float64(bytes)
+	for _, suffix := range []string{"kB", "MB", "GB"} {
+		size /= unit
+		if size < unit {
+			return fmt.Sprintf("%.1f%s", size, suffix)
+		}
+	}
+	return fmt.Sprintf("%.1fTB", size/unit)
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package progress
+
+import "os"
+
+// IsTerminal reports whether f is a terminal that can show progress bars
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type chunk struct {
+	data []byte
+	err  error
+}
+
+// downloadChunks downloads the rest of the layer as concurrent Range requests
+// and appends them to the partial blob in order, so that the partial blob is
+// always a prefix of the layer that the next attempt can resume from. It
+// returns errRangeNotResumed if the server does not support Range requests.
+func (r *Registry) downloadChunks(ctx context.Context, url string, blob *partialBlob, layer v1.Descriptor) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var offsets []int64
+	for offset := blob.size; offset < layer.Size; offset += r.chunkSize {
+		offsets = append(offsets, offset)
+	}
+
+	var progress *progressWriter
+	if r.progress != nil {
+		progress = &progressWriter{reporter: r.progress, blob: layer.Digest, written: blob.size}
+	}
+
+	results := make([]chan chunk, len(offsets))
+	for i := range results {
+		results[i] = make(chan chunk, 1)
+	}
+
+	/* a chunk only starts once a slot is free, and its slot is freed once it has been written */
+	slots := make(chan struct{}, r.chunkParallelism)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, offset := range offsets {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			end := min(offset+r.chunkSize, layer.Size) - 1
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				data, err := r.downloadChunk(ctx, url, offset, end, progress)
+				results[i] <- chunk{data: data, err: err}
+			}()
+		}
+	}()
+
+	err := func() error {
+		for i := range offsets {
+			var c chunk
+			select {
+			case c = <-results[i]:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if c.err != nil {
+				return c.err
+			}
+
+			if _, err := blob.Write(c.data); err != nil {
+				return err
+			}
+			<-slots
+		}
+		return nil
+	}()
+
+	cancel()
+	wg.Wait()
+
+	/* chunks downloaded after the one that failed were never written to the partial blob */
+	if err != nil && progress != nil {
+		r.progress.Update(layer.Digest, blob.size)
+	}
+	return err
+}
+
+func (r *Registry) downloadChunk(ctx context.Context, url string, start, end int64, progress *progressWriter) ([]byte, error) {
+	data := make([]byte, end-start+1)
+
+	headerArgs := HeaderArgs{rangeStart: start, rangeEnd: end}
+	err := r.getResource(ctx, url, headerArgs, func(resp *http.Response) error {
+		if resp.StatusCode != http.StatusPartialContent {
+			return errRangeNotResumed
+		}
+		if s, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || s != start {
+			return errRangeNotResumed
+		}
+
+		var body io.Reader = resp.Body
+		if progress != nil {
+			body = io.TeeReader(resp.Body, progress)
+		}
+
+		_, err := io.ReadFull(body, data)
+		return err
+	})
+
+	var httpErr *HTTPNotOKError
+	if errors.As(err, &httpErr) && httpErr.StatusCode() == http.StatusRequestedRangeNotSatisfiable {
+		return nil, errRangeNotResumed
+	}
+	return data, err
+}
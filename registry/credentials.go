@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	dockerHubHost      = "index.docker.io"
+	dockerHubServerURL = "https://index.docker.io/v1/"
+	credentialHelper   = "docker-credential-%s"
+	helperNotFound     = "credentials not found in native keychain"
+)
+
+type CredentialProvider interface {
+	Credentials(registry string) (Credentials, error)
+}
+
+type Credentials struct {
+	Username string
+	Password string
+}
+
+func (c Credentials) empty() bool {
+	return c.Username == "" && c.Password == ""
+}
+
+func (c Credentials) basicAuth() string {
+	return base64.StdEncoding.EncodeToString([]byte(c.Username + ":" + c.Password))
+}
+
+type staticCredentials struct {
+	host        string
+	credentials Credentials
+}
+
+// StaticCredentials returns the credentials for the given registry only, and
+// empty credentials for any other host, such as the host of a foreign layer
+func StaticCredentials(registry string, c Credentials) CredentialProvider {
+	return staticCredentials{host: normalizeRegistryHost(registry), credentials: c}
+}
+
+func (s staticCredentials) Credentials(registry string) (Credentials, error) {
+	if normalizeRegistryHost(registry) != s.host {
+		return Credentials{}, nil
+	}
+	return s.credentials, nil
+}
+
+type DockerConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+	CredsStore  string                `json:"credsStore"`
+}
+
+type dockerAuth struct {
+	Auth     string `json:"auth,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+func DefaultDockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+func LoadDockerConfig(path string) (*DockerConfig, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c DockerConfig
+	if err := json.Unmarshal(contents, &c); err != nil {
+		return nil, fmt.Errorf("invalid docker config %s: %s", path, err.Error())
+	}
+	return &c, nil
+}
+
+// Credentials looks up the registry in credHelpers, then credsStore, then
+// auths, and returns empty credentials if none of them has an entry for it
+func (c *DockerConfig) Credentials(registry string) (Credentials, error) {
+	host := normalizeRegistryHost(registry)
+
+	for key, helper := range c.CredHelpers {
+		if normalizeRegistryHost(key) == host {
+			return NewCredentialHelper(helper).Credentials(registry)
+		}
+	}
+
+	if c.CredsStore != "" {
+		creds, err := NewCredentialHelper(c.CredsStore).Credentials(registry)
+		if err != nil || !creds.empty() {
+			return creds, err
+		}
+	}
+
+	return c.authsCredentials(host)
+}
+
+func (c *DockerConfig) authsCredentials(host string) (Credentials, error) {
+	for key, auth := range c.Auths {
+		if normalizeRegistryHost(key) != host {
+			continue
+		}
+
+		if auth.Auth == "" {
+			return Credentials{Username: auth.Username, Password: auth.Password}, nil
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("invalid auth for registry %s: %s", key, err.Error())
+		}
+
+		username, password, found := strings.Cut(string(decoded), ":")
+		if !found {
+			return Credentials{}, fmt.Errorf("invalid auth for registry %s: expected username:password", key)
+		}
+		return Credentials{Username: username, Password: password}, nil
+	}
+
+	return Credentials{}, nil
+}
+
+type CredentialHelper struct {
+	name string
+}
+
+func NewCredentialHelper(name string) *CredentialHelper {
+	return &CredentialHelper{name: name}
+}
+
+// Credentials runs the helper's get command, which reads the server URL on
+// stdin and writes {"ServerURL", "Username", "Secret"} json on stdout
+func (h *CredentialHelper) Credentials(registry string) (Credentials, error) {
+	serverURL := normalizeRegistryHost(registry)
+	if serverURL == dockerHubHost {
+		serverURL = dockerHubServerURL
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(fmt.Sprintf(credentialHelper, h.name), "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stdout.String(), helperNotFound) {
+			return Credentials{}, nil
+		}
+		return Credentials{}, &CredentialHelperError{helper: h.name, Cause: err, output: strings.TrimSpace(stdout.String() + stderr.String())}
+	}
+
+	var resp struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return Credentials{}, &CredentialHelperError{helper: h.name, Cause: err}
+	}
+
+	return Credentials{Username: resp.Username, Password: resp.Secret}, nil
+}
+
+// docker config keys may be bare hosts or full URLs such as https://index.docker.io/v1/
+func normalizeRegistryHost(registry string) string {
+	host := strings.ToLower(registry)
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	host, _, _ = strings.Cut(host, "/")
+
+	switch host {
+	case "docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHubHost
+	}
+	return host
+}
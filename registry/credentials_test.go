@@ -0,0 +1,191 @@
+package registry_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/hydrator/registry"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DockerConfig", func() {
+	var (
+		configDir  string
+		configPath string
+	)
+
+	BeforeEach(func() {
+		var err error
+		configDir, err = os.MkdirTemp("", "hydrate.credentials.test")
+		Expect(err).NotTo(HaveOccurred())
+		configPath = filepath.Join(configDir, "config.json")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(configDir)).To(Succeed())
+	})
+
+	Describe("Credentials", func() {
+		BeforeEach(func() {
+			// "c29tZS11c2VyOnNvbWUtcGFzc3dvcmQ=" is base64 for "some-user:some-password"
+			Expect(os.WriteFile(configPath, []byte(`{
+				"auths": {
+					"https://index.docker.io/v1/": {"auth": "c29tZS11c2VyOnNvbWUtcGFzc3dvcmQ="},
+					"myregistry.local:5000": {"username": "other-user", "password": "other-password"},
+					"broken.example.com": {"auth": "not base64"}
+				}
+			}`), 0644)).To(Succeed())
+		})
+
+		It("decodes the auth field for docker hub", func() {
+			c, err := registry.LoadDockerConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			creds, err := c.Credentials("https://registry.hub.docker.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(creds).To(Equal(registry.Credentials{Username: "some-user", Password: "some-password"}))
+		})
+
+		It("uses the username and password fields", func() {
+			c, err := registry.LoadDockerConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			creds, err := c.Credentials("https://myregistry.local:5000")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(creds).To(Equal(registry.Credentials{Username: "other-user", Password: "other-password"}))
+		})
+
+		It("returns empty credentials for an unknown registry", func() {
+			c, err := registry.LoadDockerConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			creds, err := c.Credentials("unknown.example.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(creds).To(Equal(registry.Credentials{}))
+		})
+
+		It("returns an error when the auth field is invalid", func() {
+			c, err := registry.LoadDockerConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = c.Credentials("broken.example.com")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("the config file is not valid json", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(configPath, []byte("not json"), 0644)).To(Succeed())
+		})
+
+		It("returns an error", func() {
+			_, err := registry.LoadDockerConfig(configPath)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("credential helpers", func() {
+		var oldPath string
+
+		BeforeEach(func() {
+			oldPath = os.Getenv("PATH")
+			Expect(os.Setenv("PATH", credentialHelperDir+string(os.PathListSeparator)+oldPath)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.Setenv("PATH", oldPath)).To(Succeed())
+		})
+
+		It("gets credentials from the helper", func() {
+			creds, err := registry.NewCredentialHelper("fake").Credentials("https://helper.example.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(creds).To(Equal(registry.Credentials{Username: "helper-user", Password: "helper-secret"}))
+		})
+
+		It("asks for docker hub using the legacy server URL", func() {
+			creds, err := registry.NewCredentialHelper("fake").Credentials("registry.hub.docker.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(creds).To(Equal(registry.Credentials{Username: "hub-user", Password: "hub-secret"}))
+		})
+
+		It("returns empty credentials when the helper has none", func() {
+			creds, err := registry.NewCredentialHelper("fake").Credentials("unknown.example.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(creds).To(Equal(registry.Credentials{}))
+		})
+
+		It("returns an error when the helper fails", func() {
+			_, err := registry.NewCredentialHelper("fake").Credentials("broken.example.com")
+			Expect(err).To(BeAssignableToTypeOf(&registry.CredentialHelperError{}))
+			Expect(err.Error()).To(ContainSubstring("keychain locked"))
+		})
+
+		It("returns an error when the helper is not installed", func() {
+			_, err := registry.NewCredentialHelper("missing").Credentials("helper.example.com")
+			Expect(err).To(BeAssignableToTypeOf(&registry.CredentialHelperError{}))
+		})
+
+		Context("the docker config references the helper", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(configPath, []byte(`{
+					"auths": {
+						"other.example.com": {"username": "file-user", "password": "file-password"}
+					},
+					"credHelpers": {
+						"helper.example.com": "fake"
+					},
+					"credsStore": "fake"
+				}`), 0644)).To(Succeed())
+			})
+
+			It("uses credHelpers for the registry", func() {
+				c, err := registry.LoadDockerConfig(configPath)
+				Expect(err).NotTo(HaveOccurred())
+
+				creds, err := c.Credentials("helper.example.com")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(creds).To(Equal(registry.Credentials{Username: "helper-user", Password: "helper-secret"}))
+			})
+
+			It("uses credsStore for other registries", func() {
+				c, err := registry.LoadDockerConfig(configPath)
+				Expect(err).NotTo(HaveOccurred())
+
+				creds, err := c.Credentials("https://index.docker.io")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(creds).To(Equal(registry.Credentials{Username: "hub-user", Password: "hub-secret"}))
+			})
+
+			It("falls back to auths when credsStore has no credentials", func() {
+				c, err := registry.LoadDockerConfig(configPath)
+				Expect(err).NotTo(HaveOccurred())
+
+				creds, err := c.Credentials("other.example.com")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(creds).To(Equal(registry.Credentials{Username: "file-user", Password: "file-password"}))
+			})
+		})
+	})
+})
+
+var _ = Describe("StaticCredentials", func() {
+	var provider registry.CredentialProvider
+
+	BeforeEach(func() {
+		provider = registry.StaticCredentials("docker.io", registry.Credentials{Username: "some-user", Password: "some-password"})
+	})
+
+	It("returns the credentials for the registry", func() {
+		creds, err := provider.Credentials("index.docker.io")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds).To(Equal(registry.Credentials{Username: "some-user", Password: "some-password"}))
+	})
+
+	It("returns empty credentials for any other host", func() {
+		creds, err := provider.Credentials("foreign-layers.example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds).To(Equal(registry.Credentials{}))
+	})
+})
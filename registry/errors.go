@@ -2,8 +2,12 @@ package registry
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
 	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type SHAMismatchError struct {
@@ -15,6 +19,28 @@ func (e *SHAMismatchError) Error() string {
 	return fmt.Sprintf("sha256 mismatch: expected %s, got %s", e.expected, e.actual)
 }
 
+// a mismatch is usually a truncated or corrupted transfer
+func (e *SHAMismatchError) Retryable() bool {
+	return true
+}
+
+type SizeMismatchError struct {
+	expected int64
+	actual   int64
+}
+
+func (e *SizeMismatchError) Error() string {
+	if e.actual > e.expected {
+		return fmt.Sprintf("size mismatch: expected %d bytes, got more", e.expected)
+	}
+	return fmt.Sprintf("size mismatch: expected %d bytes, got %d", e.expected, e.actual)
+}
+
+// like a sha256 mismatch, a short blob is usually a truncated transfer
+func (e *SizeMismatchError) Retryable() bool {
+	return true
+}
+
 type DownloadError struct {
 	Cause   error
 	blobSHA string
@@ -24,6 +50,10 @@ func (e *DownloadError) Error() string {
 	return fmt.Sprintf("failed downloading blob %.8s: %s", e.blobSHA, e.Cause.Error())
 }
 
+func (e *DownloadError) Unwrap() error {
+	return e.Cause
+}
+
 type DigestAlgorithmError struct {
 	expected digest.Algorithm
 	actual   digest.Algorithm
@@ -33,14 +63,36 @@ func (e *DigestAlgorithmError) Error() string {
 	return fmt.Sprintf("invalid digest algorithm: expected %s, got %s", e.expected, e.actual)
 }
 
+func (e *DigestAlgorithmError) Retryable() bool {
+	return false
+}
+
 type HTTPNotOKError struct {
 	statusCode int
+	retryAfter time.Duration
 }
 
 func (e *HTTPNotOKError) Error() string {
 	return fmt.Sprintf("unsuccessful response from server: %d", e.statusCode)
 }
 
+func (e *HTTPNotOKError) StatusCode() int {
+	return e.statusCode
+}
+
+// RetryAfter is the delay the server asked for with a 429 or 503, or zero
+func (e *HTTPNotOKError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+func (e *HTTPNotOKError) Retryable() bool {
+	switch e.statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return e.statusCode >= 500
+}
+
 type InvalidMediaTypeError struct {
 	mediaType string
 }
@@ -48,3 +100,46 @@ type InvalidMediaTypeError struct {
 func (e *InvalidMediaTypeError) Error() string {
 	return fmt.Sprintf("invalid media type: %s", e.mediaType)
 }
+
+func (e *InvalidMediaTypeError) Retryable() bool {
+	return false
+}
+
+type UnsupportedAuthSchemeError struct {
+	scheme string
+}
+
+func (e *UnsupportedAuthSchemeError) Error() string {
+	return fmt.Sprintf("unsupported authentication scheme: %s", e.scheme)
+}
+
+type CredentialHelperError struct {
+	Cause  error
+	helper string
+	output string
+}
+
+func (e *CredentialHelperError) Error() string {
+	if e.output != "" {
+		return fmt.Sprintf("credential helper %s failed: %s: %s", e.helper, e.Cause.Error(), e.output)
+	}
+	return fmt.Sprintf("credential helper %s failed: %s", e.helper, e.Cause.Error())
+}
+
+type ManifestDigestMismatchError struct {
+	expected digest.Digest
+	actual   digest.Digest
+}
+
+func (e *ManifestDigestMismatchError) Error() string {
+	return fmt.Sprintf("manifest digest mismatch: expected %s, got %s", e.expected, e.actual)
+}
+
+type NoMatchingPlatformError struct {
+	platform  v1.Platform
+	available []string
+}
+
+func (e *NoMatchingPlatformError) Error() string {
+	return fmt.Sprintf("no image found for platform %s, available: %s", platformString(e.platform), strings.Join(e.available, ", "))
+}
@@ -0,0 +1,165 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const partialSuffix = ".partial"
+
+var errRangeNotResumed = errors.New("server did not resume from the requested offset")
+
+// partialBlob is a blob being written to <blob>.partial. It hashes what is
+// written as it goes, so that the finished blob can be verified without
+// reading it again, and so that the next attempt can resume where the last
+// one stopped.
+type partialBlob struct {
+	path string
+	file *os.File
+	hash hash.Hash
+	size int64
+}
+
+// partialState is the hash of a partial blob between attempts, which saves
+// reading the partial file again when the same process resumes it
+type partialState struct {
+	hash hash.Hash
+	size int64
+}
+
+// openPartial leaves the file offset at the end of the partial blob. It does
+// not use O_APPEND, which on Windows opens the file without the right to
+// truncate it.
+func (r *Registry) openPartial(path string) (*partialBlob, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r.partialsMutex.Lock()
+	state, ok := r.partials[path]
+	delete(r.partials, path)
+	r.partialsMutex.Unlock()
+
+	if ok && state.size == info.Size() {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &partialBlob{path: path, file: f, hash: state.hash, size: state.size}, nil
+	}
+
+	/* left by another process, or the state is out of date */
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &partialBlob{path: path, file: f, hash: h, size: size}, nil
+}
+
+// closePartial keeps the hash state for the next attempt
+func (r *Registry) closePartial(b *partialBlob) error {
+	r.partialsMutex.Lock()
+	r.partials[b.path] = partialState{hash: b.hash, size: b.size}
+	r.partialsMutex.Unlock()
+
+	return b.file.Close()
+}
+
+// removePartial is for a download that will not be resumed
+func (r *Registry) removePartial(b *partialBlob) error {
+	b.file.Close()
+	return r.forgetPartial(b.path)
+}
+
+func (r *Registry) forgetPartial(path string) error {
+	r.partialsMutex.Lock()
+	delete(r.partials, path)
+	r.partialsMutex.Unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// finishPartial moves the partial blob to dest if it matches the descriptor,
+// and otherwise removes it so that the next attempt starts again
+func (r *Registry) finishPartial(b *partialBlob, dest string, desc v1.Descriptor) error {
+	if err := b.file.Close(); err != nil {
+		return err
+	}
+
+	if desc.Size > 0 && b.size != desc.Size {
+		os.Remove(b.path)
+		return &SizeMismatchError{expected: desc.Size, actual: b.size}
+	}
+
+	expectedSHA := desc.Digest.Encoded()
+	actualSHA := fmt.Sprintf("%x", b.hash.Sum(nil))
+	if actualSHA != expectedSHA {
+		os.Remove(b.path)
+		return &SHAMismatchError{expected: expectedSHA, actual: actualSHA}
+	}
+
+	/* rename replaces dest, which may be a hard link into the blob cache, without writing to it */
+	return os.Rename(b.path, dest)
+}
+
+func (b *partialBlob) Write(p []byte) (int, error) {
+	n, err := b.file.Write(p)
+	b.hash.Write(p[:n])
+	b.size += int64(n)
+	return n, err
+}
+
+func (b *partialBlob) truncate() error {
+	if b.size == 0 {
+		return nil
+	}
+
+	if err := b.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	b.hash.Reset()
+	b.size = 0
+	return nil
+}
+
+// contentRangeStart parses the first byte position of "bytes <start>-<end>/<size>"
+func contentRangeStart(contentRange string) (int64, bool) {
+	r, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, false
+	}
+
+	start, _, ok := strings.Cut(r, "-")
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
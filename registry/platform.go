@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"strconv"
+	"strings"
+
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// selectManifest picks the index entry for the requested os and architecture.
+// For windows with an os.version, the newest entry whose build is not newer
+// than the host build wins, so the host's own build is preferred and an older
+// build, which can still run with hyper-v isolation, is the fallback. Without
+// an os.version the first match wins.
+func selectManifest(index v1.Index, platform v1.Platform) (v1.Descriptor, error) {
+	var candidates []v1.Descriptor
+	for _, m := range index.Manifests {
+		if m.Platform == nil {
+			continue
+		}
+		if m.Platform.OS != platform.OS || m.Platform.Architecture != platform.Architecture {
+			continue
+		}
+		if platform.Variant != "" && m.Platform.Variant != platform.Variant {
+			continue
+		}
+		candidates = append(candidates, m)
+	}
+
+	if len(candidates) == 0 {
+		return v1.Descriptor{}, &NoMatchingPlatformError{platform: platform, available: platforms(index)}
+	}
+
+	if platform.OS != "windows" || platform.OSVersion == "" {
+		return candidates[0], nil
+	}
+
+	hostBuild, _ := windowsBuild(platform.OSVersion)
+
+	var best *v1.Descriptor
+	for i := range candidates {
+		build, revision := windowsBuild(candidates[i].Platform.OSVersion)
+		if build > hostBuild {
+			continue
+		}
+
+		if best != nil {
+			bestBuild, bestRevision := windowsBuild(best.Platform.OSVersion)
+			if build < bestBuild || (build == bestBuild && revision <= bestRevision) {
+				continue
+			}
+		}
+		best = &candidates[i]
+	}
+
+	if best == nil {
+		return v1.Descriptor{}, &NoMatchingPlatformError{platform: platform, available: platforms(index)}
+	}
+	return *best, nil
+}
+
+// windowsBuild accepts 17763, 10.0.17763 or 10.0.17763.1577 and returns the
+// build and revision, with -1 for missing parts
+func windowsBuild(osVersion string) (int, int) {
+	parts := strings.Split(osVersion, ".")
+	if len(parts) == 1 {
+		return atoi(parts[0]), -1
+	}
+	if len(parts) < 3 {
+		return -1, -1
+	}
+	if len(parts) == 3 {
+		return atoi(parts[2]), -1
+	}
+	return atoi(parts[2]), atoi(parts[3])
+}
+
+func atoi(s string) int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return -1
+	}
+	return i
+}
+
+func platforms(index v1.Index) []string {
+	var p []string
+	for _, m := range index.Manifests {
+		if m.Platform != nil {
+			p = append(p, platformString(*m.Platform))
+		}
+	}
+	return p
+}
+
+func platformString(p v1.Platform) string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	if p.OSVersion != "" {
+		s += " " + p.OSVersion
+	}
+	return s
+}
@@ -2,23 +2,30 @@ package registry
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"code.cloudfoundry.org/hydrator/progress"
 	digest "github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 const (
-	manifestURL     = "%s/v2/%s/manifests/%s"
-	blobURL         = "%s/v2/%s/blobs/%s"
-	authServerRegex = `realm="(.*)".*service="(.*)".*scope="(.*)".*`
+	manifestURL = "%s/v2/%s/manifests/%s"
+	blobURL     = "%s/v2/%s/blobs/%s"
 )
 
 const (
@@ -27,118 +34,429 @@ const (
 	imageConfig    = "application/vnd.docker.container.image.v1+json"
 	manifestV2     = "application/vnd.docker.distribution.manifest.v2+json"
 	manifestV2List = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	ociManifest                  = v1.MediaTypeImageManifest
+	ociIndex                     = v1.MediaTypeImageIndex
+	ociConfig                    = v1.MediaTypeImageConfig
+	ociLayer                     = v1.MediaTypeImageLayer
+	ociLayerGzip                 = v1.MediaTypeImageLayerGzip
+	ociNonDistributableLayer     = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+	ociNonDistributableLayerGzip = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
 )
 
 type Registry struct {
 	registryServerURL string
 	imageName         string
-	imageTag          string
+	reference         string
+	credentials       CredentialProvider
+	platform          v1.Platform
+	client            *http.Client
+	requestTimeout    time.Duration
+	progress          progress.Reporter
+	cache             BlobCache
+	chunkSize         int64
+	chunkParallelism  int
+
+	hostCredentialsMutex sync.Mutex
+	hostCredentials      map[string]Credentials
+
+	authorizationsMutex sync.Mutex
+	authorizations      map[string]*authorization
+
+	partialsMutex sync.Mutex
+	partials      map[string]partialState
+}
+
+type Options struct {
+	Credentials CredentialProvider
+	// Platform selects the image when the reference is a manifest list or image index
+	Platform v1.Platform
+	// HTTPClient defaults to http.DefaultClient, see NewHTTPClient for TLS settings
+	HTTPClient *http.Client
+	// RequestTimeout limits each manifest, config and layer download,
+	// including authentication. Zero means no limit.
+	RequestTimeout time.Duration
+	// Progress is updated with the bytes written while downloading each layer
+	Progress progress.Reporter
+	// Cache is checked for layers before downloading them, see cache.Cache
+	Cache BlobCache
+	// ChunkSize splits layers with more than ChunkSize bytes left to download
+	// into Range requests of ChunkSize bytes, which are downloaded at once and
+	// written in order. Each chunk is held in memory until the ones before it
+	// are written. Zero downloads each layer with a single request.
+	ChunkSize int64
+	// ChunkParallelism is the most chunks of a layer downloaded at once. Zero
+	// means DefaultChunkParallelism.
+	ChunkParallelism int
+}
+
+const DefaultChunkParallelism = 4
+
+// BlobCache.Fetch writes a blob to dest from the cache, or calls download to
+// write it to dest and then adds it to the cache
+type BlobCache interface {
+	Fetch(ctx context.Context, blob digest.Digest, dest string, download func() error) (bool, error)
 }
 
-func New(registryServerURL, imageName, imageTag string) *Registry {
+// New takes either a tag or a digest as the reference. When it is a digest,
+// the manifest is verified against it.
+func New(registryServerURL, imageName, reference string, opts Options) *Registry {
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	chunkParallelism := opts.ChunkParallelism
+	if chunkParallelism <= 0 {
+		chunkParallelism = DefaultChunkParallelism
+	}
+
 	return &Registry{
 		registryServerURL: registryServerURL,
 		imageName:         imageName,
-		imageTag:          imageTag,
+		reference:         reference,
+		credentials:       opts.Credentials,
+		platform:          opts.Platform,
+		client:            client,
+		requestTimeout:    opts.RequestTimeout,
+		progress:          opts.Progress,
+		cache:             opts.Cache,
+		chunkSize:         opts.ChunkSize,
+		chunkParallelism:  chunkParallelism,
+		hostCredentials:   make(map[string]Credentials),
+		authorizations:    make(map[string]*authorization),
+		partials:          make(map[string]partialState),
 	}
 }
 
-func (r *Registry) Manifest() (v1.Manifest, error) {
+// Manifest returns the image manifest for the reference, resolving a manifest
+// list or image index for the platform, with the digest of that manifest and
+// the manifest as the registry sent it
+func (r *Registry) Manifest(ctx context.Context) (v1.Manifest, digest.Digest, []byte, error) {
+	data, err := r.downloadManifest(ctx, r.reference, 0, manifestV2, manifestV2List, ociManifest, ociIndex)
+	if err != nil {
+		return v1.Manifest{}, "", nil, err
+	}
+
+	if err := r.verifyPinnedDigest(data); err != nil {
+		return v1.Manifest{}, "", nil, err
+	}
+	manifestDigest := digest.FromBytes(data)
+
+	var index v1.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return v1.Manifest{}, "", nil, err
+	}
+
+	if isIndex(index) {
+		desc, err := selectManifest(index, r.platform)
+		if err != nil {
+			return v1.Manifest{}, "", nil, err
+		}
+
+		data, err = r.downloadManifest(ctx, string(desc.Digest), desc.Size, manifestV2, ociManifest)
+		if err != nil {
+			return v1.Manifest{}, "", nil, err
+		}
+
+		if err := checkDigest(data, desc.Digest); err != nil {
+			return v1.Manifest{}, "", nil, err
+		}
+		manifestDigest = desc.Digest
+	}
+
 	var m v1.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return v1.Manifest{}, "", nil, err
+	}
+
+	return m, manifestDigest, data, nil
+}
+
+// downloadManifest checks the manifest against the Docker-Content-Digest
+// header when the registry sends one. A size of zero is not checked.
+func (r *Registry) downloadManifest(ctx context.Context, reference string, size int64, acceptMediaTypes ...string) ([]byte, error) {
 	buffer := new(bytes.Buffer)
+	var contentDigest string
 
-	if err := r.downloadResource(r.manifestURL(), buffer, manifestV2, manifestV2List); err != nil {
-		return v1.Manifest{}, err
+	headerArgs := HeaderArgs{acceptMediaType: acceptMediaTypes}
+	err := r.getResource(ctx, r.manifestURL(reference), headerArgs, func(resp *http.Response) error {
+		contentDigest = resp.Header.Get("Docker-Content-Digest")
+		return copyLimited(buffer, resp.Body, size)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if err := json.Unmarshal(buffer.Bytes(), &m); err != nil {
-		return v1.Manifest{}, err
+	if contentDigest != "" {
+		if err := checkDigest(buffer.Bytes(), digest.Digest(contentDigest)); err != nil {
+			return nil, err
+		}
 	}
+	return buffer.Bytes(), nil
+}
 
-	return m, nil
+// the mediaType field is optional in an OCI index, so fall back to checking for manifests
+func isIndex(index v1.Index) bool {
+	switch index.MediaType {
+	case manifestV2List, ociIndex:
+		return true
+	case "":
+		return len(index.Manifests) > 0
+	}
+	return false
 }
 
-func (r *Registry) Config(config v1.Descriptor) (v1.Image, error) {
+// Config returns the image config, and the config as the registry sent it
+func (r *Registry) Config(ctx context.Context, config v1.Descriptor) (v1.Image, []byte, error) {
 	configSHA, err := getLayerSHA(config.Digest)
 	if err != nil {
-		return v1.Image{}, &DownloadError{Cause: err, blobSHA: configSHA}
+		return v1.Image{}, nil, &DownloadError{Cause: err, blobSHA: configSHA}
 	}
 
-	if config.MediaType != imageConfig {
-		return v1.Image{}, &DownloadError{Cause: &InvalidMediaTypeError{mediaType: config.MediaType}, blobSHA: configSHA}
+	if config.MediaType != imageConfig && config.MediaType != ociConfig {
+		return v1.Image{}, nil, &DownloadError{Cause: &InvalidMediaTypeError{mediaType: config.MediaType}, blobSHA: configSHA}
 	}
 
 	buffer := new(bytes.Buffer)
 
-	if err := r.downloadResource(r.blobURL(config.Digest), buffer); err != nil {
-		return v1.Image{}, &DownloadError{Cause: err, blobSHA: configSHA}
+	err = r.getResource(ctx, r.blobURL(config.Digest), HeaderArgs{}, func(resp *http.Response) error {
+		return copyLimited(buffer, resp.Body, config.Size)
+	})
+	if err != nil {
+		return v1.Image{}, nil, &DownloadError{Cause: err, blobSHA: configSHA}
 	}
 
 	receivedSHA := fmt.Sprintf("%x", sha256.Sum256(buffer.Bytes()))
 	if configSHA != receivedSHA {
-		return v1.Image{}, &DownloadError{Cause: &SHAMismatchError{expected: configSHA, actual: receivedSHA}, blobSHA: configSHA}
+		return v1.Image{}, nil, &DownloadError{Cause: &SHAMismatchError{expected: configSHA, actual: receivedSHA}, blobSHA: configSHA}
 	}
 
 	var i v1.Image
 	if err := json.Unmarshal(buffer.Bytes(), &i); err != nil {
-		return v1.Image{}, err
+		return v1.Image{}, nil, err
 	}
 
-	return i, nil
+	return i, buffer.Bytes(), nil
 }
 
-func (r *Registry) DownloadLayer(layer v1.Descriptor, outputDir string) error {
+// DownloadLayer writes the layer to outputDir, named by its sha256, unless an
+// intact copy is already there
+func (r *Registry) DownloadLayer(ctx context.Context, layer v1.Descriptor, outputDir string) error {
 	layerSHA, err := getLayerSHA(layer.Digest)
 	if err != nil {
 		return &DownloadError{Cause: err, blobSHA: layerSHA}
 	}
 
 	layerFile := filepath.Join(outputDir, layerSHA)
-	if err := r.downloadLayer(layer, layerFile); err != nil {
-		return &DownloadError{Cause: err, blobSHA: layerSHA}
+
+	/* a blob left in outputDir by an earlier download is kept if it is intact */
+	if checkSHA256(layerFile, layerSHA) == nil {
+		if r.progress != nil {
+			r.progress.Update(layer.Digest, layer.Size)
+		}
+		return nil
 	}
 
-	if err := checkSHA256(layerFile, layerSHA); err != nil {
+	download := func() error {
+		return r.downloadLayer(ctx, layer, layerFile)
+	}
+
+	if r.cache == nil {
+		if err := download(); err != nil {
+			return &DownloadError{Cause: err, blobSHA: layerSHA}
+		}
+		return nil
+	}
+
+	hit, err := r.cache.Fetch(ctx, layer.Digest, layerFile, download)
+	if err != nil {
 		return &DownloadError{Cause: err, blobSHA: layerSHA}
 	}
+	if hit && r.progress != nil {
+		r.progress.Update(layer.Digest, layer.Size)
+	}
 	return nil
 }
 
-func (r *Registry) downloadLayer(layer v1.Descriptor, outputFile string) error {
+// RemovePartial removes the partial layer that DownloadLayer keeps in
+// outputDir after a failed attempt, once no more attempts will be made
+func (r *Registry) RemovePartial(layer v1.Descriptor, outputDir string) error {
+	layerSHA, err := getLayerSHA(layer.Digest)
+	if err != nil {
+		return nil
+	}
+	return r.forgetPartial(filepath.Join(outputDir, layerSHA+partialSuffix))
+}
+
+// downloadLayer writes the layer to outputFile once it has been verified.
+// Until then it is written to outputFile.partial, which the next attempt
+// resumes with a Range request if the server supports them. The partial file
+// is removed if ctx is done.
+func (r *Registry) downloadLayer(ctx context.Context, layer v1.Descriptor, outputFile string) error {
 	var layerURL string
 
 	switch layer.MediaType {
-	case diffLayer:
+	case diffLayer, ociLayerGzip, ociLayer:
+		layerURL = r.blobURL(layer.Digest)
+	case foreignLayer, ociNonDistributableLayerGzip, ociNonDistributableLayer:
+		/* non-distributable layers may still have been pushed to the registry */
 		layerURL = r.blobURL(layer.Digest)
-	case foreignLayer:
-		layerURL = layer.URLs[0]
+		if len(layer.URLs) > 0 {
+			layerURL = layer.URLs[0]
+		}
 	default:
 		return &InvalidMediaTypeError{mediaType: layer.MediaType}
 	}
 
-	f, err := os.OpenFile(outputFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	blob, err := r.openPartial(outputFile + partialSuffix)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	if err := r.downloadResource(layerURL, f); err != nil {
+	if layer.Size > 0 && blob.size > layer.Size {
+		if err := blob.truncate(); err != nil {
+			r.closePartial(blob)
+			return err
+		}
+	}
+
+	if layer.Size <= 0 || blob.size < layer.Size {
+		if err := r.downloadRest(ctx, layerURL, blob, layer); err != nil {
+			if ctx.Err() != nil {
+				r.removePartial(blob)
+			} else {
+				r.closePartial(blob)
+			}
+			return err
+		}
+	}
+
+	return r.finishPartial(blob, outputFile, layer)
+}
+
+// downloadRest appends the rest of the layer to the partial blob, in chunks if
+// there is enough of it left
+func (r *Registry) downloadRest(ctx context.Context, url string, blob *partialBlob, layer v1.Descriptor) error {
+	if r.chunkSize > 0 && layer.Size-blob.size > r.chunkSize {
+		err := r.downloadChunks(ctx, url, blob, layer)
+		if !errors.Is(err, errRangeNotResumed) {
+			return err
+		}
+		/* the chunks written so far are kept, the server may still resume from the end of them */
+	}
+
+	err := r.resumeDownload(ctx, url, blob, layer)
+	if errors.Is(err, errRangeNotResumed) {
+		if err := blob.truncate(); err != nil {
+			return err
+		}
+		return r.resumeDownload(ctx, url, blob, layer)
+	}
+	return err
+}
+
+// resumeDownload appends the rest of the blob to the partial file. A server
+// that ignores the Range header sends the whole blob, so the partial file is
+// started again.
+func (r *Registry) resumeDownload(ctx context.Context, url string, blob *partialBlob, layer v1.Descriptor) error {
+	headerArgs := HeaderArgs{rangeStart: blob.size}
+
+	err := r.getResource(ctx, url, headerArgs, func(resp *http.Response) error {
+		switch resp.StatusCode {
+		case http.StatusOK:
+			if err := blob.truncate(); err != nil {
+				return err
+			}
+		case http.StatusPartialContent:
+			if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != blob.size {
+				return errRangeNotResumed
+			}
+		}
+
+		var output io.Writer = blob
+		if r.progress != nil {
+			r.progress.Update(layer.Digest, blob.size)
+			output = io.MultiWriter(blob, &progressWriter{reporter: r.progress, blob: layer.Digest, written: blob.size})
+		}
+
+		if layer.Size <= 0 {
+			_, err := io.Copy(output, resp.Body)
+			return err
+		}
+		return copyLimited(output, resp.Body, layer.Size-blob.size)
+	})
+
+	var httpErr *HTTPNotOKError
+	if errors.As(err, &httpErr) && httpErr.StatusCode() == http.StatusRequestedRangeNotSatisfiable && blob.size > 0 {
+		return errRangeNotResumed
+	}
+	return err
+}
+
+// copyLimited fails rather than copying more than size bytes, unless size is zero
+func copyLimited(output io.Writer, body io.Reader, size int64) error {
+	if size <= 0 {
+		_, err := io.Copy(output, body)
+		return err
+	}
+
+	n, err := io.Copy(output, io.LimitReader(body, size))
+	if err != nil {
 		return err
 	}
+
+	if n == size {
+		if _, err := io.ReadFull(body, make([]byte, 1)); err == nil {
+			return &SizeMismatchError{expected: size, actual: size + 1}
+		}
+	}
 	return nil
 }
 
-func (r *Registry) manifestURL() string {
-	return fmt.Sprintf(manifestURL, r.registryServerURL, r.imageName, r.imageTag)
+// progressWriter may be shared by the chunks of a layer
+type progressWriter struct {
+	reporter progress.Reporter
+	blob     digest.Digest
+	written  int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.reporter.Update(w.blob, atomic.AddInt64(&w.written, int64(len(p))))
+	return len(p), nil
+}
+
+func (r *Registry) verifyPinnedDigest(manifest []byte) error {
+	if !strings.Contains(r.reference, ":") {
+		return nil
+	}
+	return checkDigest(manifest, digest.Digest(r.reference))
+}
+
+func checkDigest(manifest []byte, expected digest.Digest) error {
+	expectedSHA, err := getLayerSHA(expected)
+	if err != nil {
+		return err
+	}
+
+	actualSHA := fmt.Sprintf("%x", sha256.Sum256(manifest))
+	if actualSHA != expectedSHA {
+		return &ManifestDigestMismatchError{expected: expected, actual: digest.NewDigestFromEncoded(digest.SHA256, actualSHA)}
+	}
+	return nil
+}
+
+func (r *Registry) manifestURL(reference string) string {
+	return fmt.Sprintf(manifestURL, r.registryServerURL, r.imageName, reference)
 }
 
 func (r *Registry) blobURL(d digest.Digest) string {
 	return fmt.Sprintf(blobURL, r.registryServerURL, r.imageName, d)
 }
 
-func (r *Registry) downloadRequest(url string, headerArgs HeaderArgs) (*http.Response, error) {
+func (r *Registry) downloadRequest(ctx context.Context, url string, headerArgs HeaderArgs) (*http.Response, error) {
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -147,57 +465,214 @@ func (r *Registry) downloadRequest(url string, headerArgs HeaderArgs) (*http.Res
 		req.Header.Add("Accept", mediaType)
 	}
 
-	if headerArgs.authToken != "" {
-		req.Header.Add("Authorization", "Bearer "+headerArgs.authToken)
+	if headerArgs.authorization != "" {
+		req.Header.Add("Authorization", headerArgs.authorization)
 	}
 
-	return http.DefaultClient.Do(req)
+	if headerArgs.rangeEnd > 0 {
+		req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", headerArgs.rangeStart, headerArgs.rangeEnd))
+	} else if headerArgs.rangeStart > 0 {
+		req.Header.Add("Range", fmt.Sprintf("bytes=%d-", headerArgs.rangeStart))
+	}
+
+	return r.client.Do(req)
 }
 
 type HeaderArgs struct {
 	acceptMediaType []string
-	authToken       string
+	authorization   string
+	rangeStart      int64
+	// rangeEnd is inclusive, zero means the end of the resource
+	rangeEnd int64
 }
 
-func (r *Registry) downloadResource(url string, output io.Writer, acceptMediaTypes ...string) error {
-	headerArgs := HeaderArgs{acceptMediaType: acceptMediaTypes, authToken: ""}
+// getResource authenticates if the server asks for it and passes a 200 or 206
+// response to read. The request timeout covers reading the body.
+func (r *Registry) getResource(ctx context.Context, url string, headerArgs HeaderArgs, read func(*http.Response) error) error {
+	if r.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.requestTimeout)
+		defer cancel()
+	}
+
+	auth := r.authorizationFor(url)
+	if headerArgs.authorization == "" {
+		headerArgs.authorization = auth.get()
+	}
 
-	resp, err := r.downloadRequest(url, headerArgs)
+	resp, err := r.downloadRequest(ctx, url, headerArgs)
 	if err != nil {
 		return err
 	}
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		defer resp.Body.Close()
-		_, err = io.Copy(output, resp.Body)
-		return err
-	case http.StatusUnauthorized:
-		token, err := r.getToken(resp.Header.Get("Www-Authenticate"))
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+
+		host, challenge := resp.Request.URL.Host, resp.Header.Get("Www-Authenticate")
+		authorization, err := auth.refresh(headerArgs.authorization, func() (string, error) {
+			return r.authorize(ctx, host, challenge)
+		})
 		if err != nil {
 			return err
 		}
 
-		headerArgs.authToken = token
-		resp, err := r.downloadRequest(url, headerArgs)
+		headerArgs.authorization = authorization
+		resp, err = r.downloadRequest(ctx, url, headerArgs)
 		if err != nil {
-			return &HTTPNotOKError{statusCode: resp.StatusCode}
+			return err
 		}
+	}
+	defer resp.Body.Close()
 
-		defer resp.Body.Close()
-		_, err = io.Copy(output, resp.Body)
-		return err
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return httpNotOKError(resp)
+	}
+
+	return read(resp)
+}
+
+func httpNotOKError(resp *http.Response) *HTTPNotOKError {
+	err := &HTTPNotOKError{statusCode: resp.StatusCode}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		err.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return err
+}
+
+// parseRetryAfter accepts either a number of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+func (r *Registry) authorize(ctx context.Context, host, challenge string) (string, error) {
+	scheme, params := parseChallenge(challenge)
+
+	credentials, err := r.credentialsFor(host)
+	if err != nil {
+		return "", err
+	}
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if credentials.empty() {
+			return "", &HTTPNotOKError{statusCode: http.StatusUnauthorized}
+		}
+		return "Basic " + credentials.basicAuth(), nil
+	case "bearer":
+		token, err := r.getToken(ctx, params, credentials)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
 	default:
-		return &HTTPNotOKError{statusCode: resp.StatusCode}
+		return "", &UnsupportedAuthSchemeError{scheme: scheme}
 	}
 }
 
-func (r *Registry) getToken(authenticateInfo string) (string, error) {
-	re := regexp.MustCompile(authServerRegex)
-	authInfo := re.FindStringSubmatch(authenticateInfo)
-	authEndpoint, registryEndpoint, scope := authInfo[1], authInfo[2], authInfo[3]
+// authorization is shared by the requests for a URL, such as the chunks of a
+// layer, so that they authenticate once between them
+type authorization struct {
+	mutex sync.Mutex
+	value string
+}
+
+func (r *Registry) authorizationFor(url string) *authorization {
+	r.authorizationsMutex.Lock()
+	defer r.authorizationsMutex.Unlock()
+
+	a, ok := r.authorizations[url]
+	if !ok {
+		a = &authorization{}
+		r.authorizations[url] = a
+	}
+	return a
+}
+
+func (a *authorization) get() string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.value
+}
+
+// refresh replaces the authorization the server rejected, unless another
+// request has already replaced it
+func (a *authorization) refresh(rejected string, authorize func() (string, error)) (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.value != rejected {
+		return a.value, nil
+	}
+
+	value, err := authorize()
+	if err != nil {
+		return "", err
+	}
+	a.value = value
+	return value, nil
+}
+
+// credentialsFor only asks the provider once per host, since credential
+// helpers are external processes and layers are downloaded concurrently
+func (r *Registry) credentialsFor(host string) (Credentials, error) {
+	if r.credentials == nil {
+		return Credentials{}, nil
+	}
+
+	r.hostCredentialsMutex.Lock()
+	defer r.hostCredentialsMutex.Unlock()
+
+	if c, ok := r.hostCredentials[host]; ok {
+		return c, nil
+	}
+
+	c, err := r.credentials.Credentials(host)
+	if err != nil {
+		return Credentials{}, err
+	}
 
-	resp, err := http.Get(fmt.Sprintf("%s?service=%s&scope=%s", authEndpoint, registryEndpoint, scope))
+	r.hostCredentials[host] = c
+	return c, nil
+}
+
+func (r *Registry) getToken(ctx context.Context, params map[string]string, credentials Credentials) (string, error) {
+	authURL, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", err
+	}
+
+	query := authURL.Query()
+	for _, key := range []string{"service", "scope"} {
+		if value, ok := params[key]; ok {
+			query.Set(key, value)
+		}
+	}
+	authURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", authURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if !credentials.empty() {
+		req.Header.Add("Authorization", "Basic "+credentials.basicAuth())
+	}
+
+	resp, err := r.client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -213,16 +688,54 @@ func (r *Registry) getToken(authenticateInfo string) (string, error) {
 	}
 
 	var token struct {
-		Token string
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
 	}
 
 	if err := json.Unmarshal(body, &token); err != nil {
 		return "", err
 	}
 
+	if token.Token == "" {
+		return token.AccessToken, nil
+	}
 	return token.Token, nil
 }
 
+// parseChallenge splits a Www-Authenticate header such as
+// Bearer realm="https://auth.example.com/token",service="example.com",scope="repository:foo:pull"
+// into its scheme and parameters
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := make(map[string]string)
+
+	rest = strings.TrimSpace(rest)
+	for rest != "" {
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+
+		rest = strings.TrimLeft(rest, ", ")
+	}
+
+	return scheme, params
+}
+
 func checkSHA256(file, expected string) error {
 	f, err := os.Open(file)
 	if err != nil {
@@ -1,8 +1,11 @@
 package registry_test
 
 import (
+	"path/filepath"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
 
 	"testing"
 )
@@ -11,3 +14,16 @@ func TestRegistry(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Registry Suite")
 }
+
+/* the directory of the docker-credential-fake helper, to put on the PATH */
+var credentialHelperDir string
+
+var _ = BeforeSuite(func() {
+	helper, err := gexec.Build("code.cloudfoundry.org/hydrator/testhelpers/docker-credential-fake")
+	Expect(err).NotTo(HaveOccurred())
+	credentialHelperDir = filepath.Dir(helper)
+})
+
+var _ = AfterSuite(func() {
+	gexec.CleanupBuildArtifacts()
+})
@@ -1,13 +1,19 @@
 package registry_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"code.cloudfoundry.org/hydrator/cache"
+	progressfakes "code.cloudfoundry.org/hydrator/progress/fakes"
 	"code.cloudfoundry.org/hydrator/registry"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -33,7 +39,7 @@ var _ = Describe("Registry", func() {
 		var err error
 		authServer = ghttp.NewServer()
 		registryServer = ghttp.NewServer()
-		r = registry.New(registryServer.URL(), imageName, imageRef)
+		r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{})
 
 		outputDir, err = os.MkdirTemp("", "hydrate.registry.test")
 		Expect(err).NotTo(HaveOccurred())
@@ -55,17 +61,61 @@ var _ = Describe("Registry", func() {
 					registryServer.AppendHandlers(
 						ghttp.CombineHandlers(
 							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/manifests/%s", imageName, imageRef), ""),
-							ghttp.VerifyHeader(http.Header{"Accept": []string{"application/vnd.docker.distribution.manifest.v2+json", "application/vnd.docker.distribution.manifest.list.v2+json"}}),
+							ghttp.VerifyHeader(http.Header{"Accept": []string{"application/vnd.docker.distribution.manifest.v2+json", "application/vnd.docker.distribution.manifest.list.v2+json", "application/vnd.oci.image.manifest.v1+json", "application/vnd.oci.image.index.v1+json"}}),
 							ghttp.RespondWith(http.StatusOK, marshaledManifest),
 						),
 					)
 				})
 
 				It("returns a manifest for the given image and ref", func() {
-					actualManifest, err := r.Manifest()
+					actualManifest, _, _, err := r.Manifest(context.Background())
 					Expect(err).NotTo(HaveOccurred())
 					Expect(actualManifest).To(Equal(manifest))
 				})
+
+				It("returns the digest of the manifest and the manifest as it was sent", func() {
+					marshaledManifest, err := json.Marshal(manifest)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, manifestDigest, data, err := r.Manifest(context.Background())
+					Expect(err).NotTo(HaveOccurred())
+					Expect(manifestDigest).To(Equal(digest.FromBytes(marshaledManifest)))
+					Expect(data).To(Equal(marshaledManifest))
+				})
+			})
+
+			Context("the registry sends a Docker-Content-Digest header", func() {
+				var marshaledManifest []byte
+
+				BeforeEach(func() {
+					var err error
+					manifest = v1.Manifest{Config: v1.Descriptor{MediaType: "some-media-type"}}
+					marshaledManifest, err = json.Marshal(manifest)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("checks the manifest against it", func() {
+					registryServer.AppendHandlers(
+						ghttp.RespondWith(http.StatusOK, marshaledManifest, http.Header{
+							"Docker-Content-Digest": []string{digest.FromBytes(marshaledManifest).String()},
+						}),
+					)
+
+					_, manifestDigest, _, err := r.Manifest(context.Background())
+					Expect(err).NotTo(HaveOccurred())
+					Expect(manifestDigest).To(Equal(digest.FromBytes(marshaledManifest)))
+				})
+
+				It("returns an error when the manifest does not match it", func() {
+					registryServer.AppendHandlers(
+						ghttp.RespondWith(http.StatusOK, marshaledManifest, http.Header{
+							"Docker-Content-Digest": []string{digest.FromString("some-other-manifest").String()},
+						}),
+					)
+
+					_, _, _, err := r.Manifest(context.Background())
+					Expect(err).To(BeAssignableToTypeOf(&registry.ManifestDigestMismatchError{}))
+				})
 			})
 
 			Context("the registry server returns a non-200 response", func() {
@@ -79,12 +129,197 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					_, err := r.Manifest()
+					_, _, _, err := r.Manifest(context.Background())
 					Expect(err).To(BeAssignableToTypeOf(&registry.HTTPNotOKError{}))
 				})
 			})
 		})
 
+		Describe("when the reference is a manifest list", func() {
+			var (
+				manifests map[string][]byte
+				list      v1.Index
+			)
+
+			BeforeEach(func() {
+				manifests = map[string][]byte{}
+				list = v1.Index{MediaType: "application/vnd.docker.distribution.manifest.list.v2+json"}
+
+				for _, p := range []v1.Platform{
+					{OS: "linux", Architecture: "amd64"},
+					{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1577"},
+					{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.2000"},
+					{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.100"},
+				} {
+					platform := p
+					m, err := json.Marshal(v1.Manifest{Config: v1.Descriptor{MediaType: platform.OS + " " + platform.OSVersion}})
+					Expect(err).NotTo(HaveOccurred())
+					d := digest.FromBytes(m)
+					manifests[string(d)] = m
+					list.Manifests = append(list.Manifests, v1.Descriptor{
+						MediaType: "application/vnd.docker.distribution.manifest.v2+json",
+						Digest:    d,
+						Size:      int64(len(m)),
+						Platform:  &platform,
+					})
+				}
+			})
+
+			serveListAndManifest := func(osVersion string) {
+				marshaledList, err := json.Marshal(list)
+				Expect(err).NotTo(HaveOccurred())
+
+				var selected digest.Digest
+				for _, m := range list.Manifests {
+					if m.Platform.OS == "windows" && m.Platform.OSVersion == osVersion {
+						selected = m.Digest
+					}
+				}
+
+				registryServer.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/manifests/%s", imageName, imageRef), ""),
+						ghttp.RespondWith(http.StatusOK, marshaledList),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/manifests/%s", imageName, selected), ""),
+						ghttp.RespondWith(http.StatusOK, manifests[string(selected)]),
+					),
+				)
+			}
+
+			It("returns the first manifest for the platform when no os version is requested", func() {
+				r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{Platform: v1.Platform{OS: "windows", Architecture: "amd64"}})
+				serveListAndManifest("10.0.17763.1577")
+
+				m, _, _, err := r.Manifest(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(m.Config.MediaType).To(Equal("windows 10.0.17763.1577"))
+			})
+
+			It("returns the digest of the manifest for the platform rather than the list", func() {
+				r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{Platform: v1.Platform{OS: "windows", Architecture: "amd64"}})
+				serveListAndManifest("10.0.17763.1577")
+
+				_, manifestDigest, data, err := r.Manifest(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifestDigest).To(Equal(list.Manifests[1].Digest))
+				Expect(data).To(Equal(manifests[string(list.Manifests[1].Digest)]))
+			})
+
+			It("returns the newest revision of the requested windows build", func() {
+				r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{Platform: v1.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763"}})
+				serveListAndManifest("10.0.17763.2000")
+
+				m, _, _, err := r.Manifest(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(m.Config.MediaType).To(Equal("windows 10.0.17763.2000"))
+			})
+
+			It("falls back to the newest older build", func() {
+				r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{Platform: v1.Platform{OS: "windows", Architecture: "amd64", OSVersion: "19041"}})
+				serveListAndManifest("10.0.17763.2000")
+
+				m, _, _, err := r.Manifest(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(m.Config.MediaType).To(Equal("windows 10.0.17763.2000"))
+			})
+
+			It("returns an error when only newer builds are available", func() {
+				r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{Platform: v1.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.14393"}})
+				marshaledList, err := json.Marshal(list)
+				Expect(err).NotTo(HaveOccurred())
+				registryServer.AppendHandlers(ghttp.RespondWith(http.StatusOK, marshaledList))
+
+				_, _, _, err = r.Manifest(context.Background())
+				Expect(err).To(BeAssignableToTypeOf(&registry.NoMatchingPlatformError{}))
+			})
+
+			It("returns an error when no manifest matches the architecture", func() {
+				r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{Platform: v1.Platform{OS: "windows", Architecture: "arm64"}})
+				marshaledList, err := json.Marshal(list)
+				Expect(err).NotTo(HaveOccurred())
+				registryServer.AppendHandlers(ghttp.RespondWith(http.StatusOK, marshaledList))
+
+				_, _, _, err = r.Manifest(context.Background())
+				Expect(err).To(BeAssignableToTypeOf(&registry.NoMatchingPlatformError{}))
+				Expect(err.Error()).To(ContainSubstring("windows/amd64 10.0.20348.100"))
+			})
+
+			It("returns an error when the manifest does not match the digest in the list", func() {
+				r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{Platform: v1.Platform{OS: "linux", Architecture: "amd64"}})
+				marshaledList, err := json.Marshal(list)
+				Expect(err).NotTo(HaveOccurred())
+				registryServer.AppendHandlers(
+					ghttp.RespondWith(http.StatusOK, marshaledList),
+					ghttp.RespondWith(http.StatusOK, []byte(`{"schemaVersion": 2}`)),
+				)
+
+				_, _, _, err = r.Manifest(context.Background())
+				Expect(err).To(BeAssignableToTypeOf(&registry.ManifestDigestMismatchError{}))
+			})
+
+			It("resolves an OCI image index without a media type", func() {
+				list.MediaType = ""
+				r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{Platform: v1.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348"}})
+				serveListAndManifest("10.0.20348.100")
+
+				m, _, _, err := r.Manifest(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(m.Config.MediaType).To(Equal("windows 10.0.20348.100"))
+			})
+		})
+
+		Describe("when the reference is a digest", func() {
+			var (
+				marshaledManifest []byte
+				manifestDigest    digest.Digest
+			)
+
+			BeforeEach(func() {
+				var err error
+				manifest = v1.Manifest{Config: v1.Descriptor{MediaType: "some-media-type"}}
+				marshaledManifest, err = json.Marshal(manifest)
+				Expect(err).NotTo(HaveOccurred())
+				manifestDigest = digest.FromBytes(marshaledManifest)
+			})
+
+			Context("the manifest matches the digest", func() {
+				BeforeEach(func() {
+					r = registry.New(registryServer.URL(), imageName, manifestDigest.String(), registry.Options{})
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/manifests/%s", imageName, manifestDigest), ""),
+							ghttp.RespondWith(http.StatusOK, marshaledManifest),
+						),
+					)
+				})
+
+				It("returns the manifest", func() {
+					actualManifest, _, _, err := r.Manifest(context.Background())
+					Expect(err).NotTo(HaveOccurred())
+					Expect(actualManifest).To(Equal(manifest))
+				})
+			})
+
+			Context("the manifest does not match the digest", func() {
+				BeforeEach(func() {
+					r = registry.New(registryServer.URL(), imageName, digest.FromString("something else").String(), registry.Options{})
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/manifests/%s", imageName, digest.FromString("something else")), ""),
+							ghttp.RespondWith(http.StatusOK, marshaledManifest),
+						),
+					)
+				})
+
+				It("returns an error", func() {
+					_, _, _, err := r.Manifest(context.Background())
+					Expect(err).To(BeAssignableToTypeOf(&registry.ManifestDigestMismatchError{}))
+				})
+			})
+		})
+
 		Describe("when authentication is required", func() {
 			Context("successful download", func() {
 				BeforeEach(func() {
@@ -101,7 +336,7 @@ var _ = Describe("Registry", func() {
 						ghttp.CombineHandlers(
 							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/manifests/%s", imageName, imageRef), ""),
 							ghttp.VerifyHeader(http.Header{"Authorization": []string{"Bearer " + token}}),
-							ghttp.VerifyHeader(http.Header{"Accept": []string{"application/vnd.docker.distribution.manifest.v2+json", "application/vnd.docker.distribution.manifest.list.v2+json"}}),
+							ghttp.VerifyHeader(http.Header{"Accept": []string{"application/vnd.docker.distribution.manifest.v2+json", "application/vnd.docker.distribution.manifest.list.v2+json", "application/vnd.oci.image.manifest.v1+json", "application/vnd.oci.image.index.v1+json"}}),
 							ghttp.RespondWith(http.StatusOK, marshaledManifest),
 						),
 					)
@@ -115,7 +350,7 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns a manifest for the given image and ref", func() {
-					actualManifest, err := r.Manifest()
+					actualManifest, _, _, err := r.Manifest(context.Background())
 					Expect(err).NotTo(HaveOccurred())
 					Expect(actualManifest).To(Equal(manifest))
 				})
@@ -141,7 +376,85 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					_, err := r.Manifest()
+					_, _, _, err := r.Manifest(context.Background())
+					Expect(err).To(BeAssignableToTypeOf(&registry.HTTPNotOKError{}))
+				})
+			})
+
+			Context("credentials are provided", func() {
+				BeforeEach(func() {
+					r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{Credentials: registry.StaticCredentials(registryServer.URL(), registry.Credentials{Username: "some-user", Password: "some-password"})})
+
+					manifest = v1.Manifest{Config: v1.Descriptor{MediaType: "some-media-type"}}
+					marshaledManifest, err := json.Marshal(manifest)
+					Expect(err).NotTo(HaveOccurred())
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/manifests/%s", imageName, imageRef), ""),
+							ghttp.RespondWith(http.StatusUnauthorized, nil, http.Header{"Www-Authenticate": []string{
+								fmt.Sprintf(`Bearer realm="%s/token",service="%s",scope="repository:%s:pull"`, authServer.URL(), "some-registry-server.io", imageName),
+							}}),
+						),
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/manifests/%s", imageName, imageRef), ""),
+							ghttp.VerifyHeader(http.Header{"Authorization": []string{"Bearer " + token}}),
+							ghttp.RespondWith(http.StatusOK, marshaledManifest),
+						),
+					)
+
+					authServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", "/token", fmt.Sprintf("service=some-registry-server.io&scope=repository:%s:pull", imageName)),
+							ghttp.VerifyBasicAuth("some-user", "some-password"),
+							ghttp.RespondWith(http.StatusOK, fmt.Sprintf(`{"access_token": "%s"}`, token)),
+						),
+					)
+				})
+
+				It("authenticates with the auth server using the credentials", func() {
+					actualManifest, _, _, err := r.Manifest(context.Background())
+					Expect(err).NotTo(HaveOccurred())
+					Expect(actualManifest).To(Equal(manifest))
+				})
+			})
+		})
+
+		Describe("when basic authentication is required", func() {
+			BeforeEach(func() {
+				registryServer.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/manifests/%s", imageName, imageRef), ""),
+						ghttp.RespondWith(http.StatusUnauthorized, nil, http.Header{"Www-Authenticate": []string{`Basic realm="some-registry"`}}),
+					),
+				)
+			})
+
+			Context("credentials are provided", func() {
+				BeforeEach(func() {
+					r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{Credentials: registry.StaticCredentials(registryServer.URL(), registry.Credentials{Username: "some-user", Password: "some-password"})})
+
+					manifest = v1.Manifest{Config: v1.Descriptor{MediaType: "some-media-type"}}
+					marshaledManifest, err := json.Marshal(manifest)
+					Expect(err).NotTo(HaveOccurred())
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/manifests/%s", imageName, imageRef), ""),
+							ghttp.VerifyBasicAuth("some-user", "some-password"),
+							ghttp.RespondWith(http.StatusOK, marshaledManifest),
+						),
+					)
+				})
+
+				It("retries the request with basic auth", func() {
+					actualManifest, _, _, err := r.Manifest(context.Background())
+					Expect(err).NotTo(HaveOccurred())
+					Expect(actualManifest).To(Equal(manifest))
+				})
+			})
+
+			Context("credentials are not provided", func() {
+				It("returns an error", func() {
+					_, _, _, err := r.Manifest(context.Background())
 					Expect(err).To(BeAssignableToTypeOf(&registry.HTTPNotOKError{}))
 				})
 			})
@@ -171,17 +484,195 @@ var _ = Describe("Registry", func() {
 
 					foreignServer.AppendHandlers(
 						ghttp.CombineHandlers(
-							ghttp.VerifyRequest("GET", "/", ""),
+							ghttp.VerifyRequest("GET", "/", ""),
+							ghttp.RespondWith(http.StatusOK, []byte(layerData)),
+						),
+					)
+				})
+				AfterEach(func() {
+					foreignServer.Close()
+				})
+
+				It("downloads a layer for the given image and blob digest", func() {
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+				})
+
+				Context("the foreign layer server asks for credentials", func() {
+					BeforeEach(func() {
+						r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{Credentials: registry.StaticCredentials(registryServer.URL(), registry.Credentials{Username: "some-user", Password: "some-password"})})
+
+						foreignServer.SetHandler(0, ghttp.CombineHandlers(
+							func(w http.ResponseWriter, req *http.Request) {
+								Expect(req.Header.Get("Authorization")).To(BeEmpty())
+							},
+							ghttp.RespondWith(http.StatusUnauthorized, nil, http.Header{"Www-Authenticate": []string{`Basic realm="some-foreign-server"`}}),
+						))
+					})
+
+					It("does not send it the registry credentials", func() {
+						err := r.DownloadLayer(context.Background(), layer, outputDir)
+						var notOK *registry.HTTPNotOKError
+						Expect(errors.As(err, &notOK)).To(BeTrue())
+						Expect(notOK.StatusCode()).To(Equal(http.StatusUnauthorized))
+						Expect(foreignServer.ReceivedRequests()).To(HaveLen(1))
+					})
+				})
+			})
+
+			Context("for a docker hosted layer", func() {
+				BeforeEach(func() {
+					layer = v1.Descriptor{
+						Digest:    digest.NewDigestFromEncoded("sha256", layerSHA),
+						MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+					}
+
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/blobs/%s", imageName, layer.Digest), ""),
+							ghttp.RespondWith(http.StatusOK, []byte(layerData)),
+						),
+					)
+				})
+
+				It("downloads a layer for the given image and blob digest", func() {
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+				})
+			})
+
+			Context("the layer is already in the output directory", func() {
+				BeforeEach(func() {
+					layer = v1.Descriptor{
+						Digest:    digest.NewDigestFromEncoded("sha256", layerSHA),
+						MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+					}
+				})
+
+				It("does not download it again", func() {
+					Expect(os.WriteFile(filepath.Join(outputDir, layerSHA), []byte(layerData), 0644)).To(Succeed())
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+					Expect(registryServer.ReceivedRequests()).To(BeEmpty())
+				})
+
+				It("downloads it again if it is corrupt", func() {
+					Expect(os.WriteFile(filepath.Join(outputDir, layerSHA), []byte("some-corrupt-data"), 0644)).To(Succeed())
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/blobs/%s", imageName, layer.Digest), ""),
+							ghttp.RespondWith(http.StatusOK, []byte(layerData)),
+						),
+					)
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+					Expect(registryServer.ReceivedRequests()).To(HaveLen(1))
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+				})
+			})
+
+			Context("a blob cache is used", func() {
+				var (
+					cacheDir   string
+					secondDir  string
+					blobsCache *cache.Cache
+				)
+
+				BeforeEach(func() {
+					var err error
+					cacheDir, err = os.MkdirTemp("", "hydrate.registry.cache")
+					Expect(err).NotTo(HaveOccurred())
+					secondDir, err = os.MkdirTemp("", "hydrate.registry.test")
+					Expect(err).NotTo(HaveOccurred())
+
+					blobsCache, err = cache.New(cacheDir)
+					Expect(err).NotTo(HaveOccurred())
+
+					layer = v1.Descriptor{
+						Digest:    digest.NewDigestFromEncoded("sha256", layerSHA),
+						MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+					}
+					r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{Cache: blobsCache})
+
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/blobs/%s", imageName, layer.Digest), ""),
+							ghttp.RespondWith(http.StatusOK, []byte(layerData)),
+						),
+					)
+				})
+
+				AfterEach(func() {
+					Expect(os.RemoveAll(cacheDir)).To(Succeed())
+					Expect(os.RemoveAll(secondDir)).To(Succeed())
+				})
+
+				It("only downloads the layer once", func() {
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+					Expect(r.DownloadLayer(context.Background(), layer, secondDir)).To(Succeed())
+
+					Expect(registryServer.ReceivedRequests()).To(HaveLen(1))
+					data, err := os.ReadFile(filepath.Join(secondDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+				})
+			})
+
+			Context("progress is being reported", func() {
+				var reporter *progressfakes.Reporter
+
+				BeforeEach(func() {
+					layer = v1.Descriptor{
+						Digest:    digest.NewDigestFromEncoded("sha256", layerSHA),
+						MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+					}
+					reporter = &progressfakes.Reporter{}
+					r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{Progress: reporter})
+
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/blobs/%s", imageName, layer.Digest), ""),
+							ghttp.RespondWith(http.StatusOK, []byte(layerData)),
+						),
+					)
+				})
+
+				It("reports the bytes written", func() {
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+
+					Expect(reporter.UpdateCallCount()).To(BeNumerically(">", 0))
+					blob, done := reporter.UpdateArgsForCall(reporter.UpdateCallCount() - 1)
+					Expect(blob).To(Equal(layer.Digest))
+					Expect(done).To(Equal(int64(len(layerData))))
+				})
+			})
+
+			Context("for an OCI layer", func() {
+				BeforeEach(func() {
+					layer = v1.Descriptor{
+						Digest:    digest.NewDigestFromEncoded("sha256", layerSHA),
+						MediaType: "application/vnd.oci.image.layer.v1.tar+gzip",
+					}
+
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/blobs/%s", imageName, layer.Digest), ""),
 							ghttp.RespondWith(http.StatusOK, []byte(layerData)),
 						),
 					)
 				})
-				AfterEach(func() {
-					foreignServer.Close()
-				})
 
 				It("downloads a layer for the given image and blob digest", func() {
-					Expect(r.DownloadLayer(layer, outputDir)).To(Succeed())
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
 
 					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
 					Expect(err).NotTo(HaveOccurred())
@@ -189,11 +680,11 @@ var _ = Describe("Registry", func() {
 				})
 			})
 
-			Context("for a docker hosted layer", func() {
+			Context("for an OCI non-distributable layer without urls", func() {
 				BeforeEach(func() {
 					layer = v1.Descriptor{
 						Digest:    digest.NewDigestFromEncoded("sha256", layerSHA),
-						MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+						MediaType: "application/vnd.oci.image.layer.nondistributable.v1.tar",
 					}
 
 					registryServer.AppendHandlers(
@@ -204,8 +695,8 @@ var _ = Describe("Registry", func() {
 					)
 				})
 
-				It("downloads a layer for the given image and blob digest", func() {
-					Expect(r.DownloadLayer(layer, outputDir)).To(Succeed())
+				It("downloads the layer from the registry", func() {
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
 
 					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
 					Expect(err).NotTo(HaveOccurred())
@@ -229,12 +720,57 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					err := r.DownloadLayer(layer, outputDir)
+					err := r.DownloadLayer(context.Background(), layer, outputDir)
 					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
 					Expect(err.(*registry.DownloadError).Cause).To(BeAssignableToTypeOf(&registry.SHAMismatchError{}))
 				})
 			})
 
+			Context("the registry sends more than the layer size", func() {
+				BeforeEach(func() {
+					layer = v1.Descriptor{
+						Digest:    digest.NewDigestFromEncoded("sha256", layerSHA),
+						MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+						Size:      int64(len(layerData)),
+					}
+
+					registryServer.AppendHandlers(
+						ghttp.RespondWith(http.StatusOK, []byte(layerData+"-and-more")),
+					)
+				})
+
+				It("stops reading at the layer size and returns an error", func() {
+					err := r.DownloadLayer(context.Background(), layer, outputDir)
+					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
+					Expect(err.(*registry.DownloadError).Cause).To(BeAssignableToTypeOf(&registry.SizeMismatchError{}))
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA+".partial"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+				})
+			})
+
+			Context("the registry sends less than the layer size", func() {
+				BeforeEach(func() {
+					layer = v1.Descriptor{
+						Digest:    digest.NewDigestFromEncoded("sha256", layerSHA),
+						MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+						Size:      int64(len(layerData)) + 1,
+					}
+
+					registryServer.AppendHandlers(
+						ghttp.RespondWith(http.StatusOK, []byte(layerData)),
+					)
+				})
+
+				It("returns an error and removes the partial layer", func() {
+					err := r.DownloadLayer(context.Background(), layer, outputDir)
+					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
+					Expect(err.(*registry.DownloadError).Cause).To(BeAssignableToTypeOf(&registry.SizeMismatchError{}))
+					Expect(filepath.Join(outputDir, layerSHA+".partial")).NotTo(BeAnExistingFile())
+				})
+			})
+
 			Context("the digest algorithm is not sha256", func() {
 				BeforeEach(func() {
 					layer = v1.Descriptor{
@@ -243,7 +779,7 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					err := r.DownloadLayer(layer, outputDir)
+					err := r.DownloadLayer(context.Background(), layer, outputDir)
 					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
 					Expect(err.(*registry.DownloadError).Cause).To(BeAssignableToTypeOf(&registry.DigestAlgorithmError{}))
 				})
@@ -257,7 +793,7 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					err := r.DownloadLayer(layer, outputDir)
+					err := r.DownloadLayer(context.Background(), layer, outputDir)
 					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
 					Expect(err.(*registry.DownloadError).Cause.Error()).To(Equal("invalid checksum digest format"))
 				})
@@ -279,9 +815,373 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					err := r.DownloadLayer(layer, outputDir)
+					err := r.DownloadLayer(context.Background(), layer, outputDir)
 					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
 					Expect(err.(*registry.DownloadError).Cause).To(BeAssignableToTypeOf(&registry.HTTPNotOKError{}))
+					Expect(err.(*registry.DownloadError).Cause.(*registry.HTTPNotOKError).Retryable()).To(BeFalse())
+				})
+			})
+
+			Context("the registry server is rate limiting", func() {
+				BeforeEach(func() {
+					layer = v1.Descriptor{
+						Digest:    digest.NewDigestFromEncoded("sha256", layerSHA),
+						MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+					}
+
+					registryServer.AppendHandlers(
+						ghttp.RespondWith(http.StatusTooManyRequests, nil, http.Header{"Retry-After": []string{"120"}}),
+						ghttp.RespondWith(http.StatusServiceUnavailable, nil, http.Header{"Retry-After": []string{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}),
+					)
+				})
+
+				It("returns a retryable error with the delay the server asked for", func() {
+					err := r.DownloadLayer(context.Background(), layer, outputDir)
+					var notOK *registry.HTTPNotOKError
+					Expect(errors.As(err, &notOK)).To(BeTrue())
+					Expect(notOK.StatusCode()).To(Equal(http.StatusTooManyRequests))
+					Expect(notOK.RetryAfter()).To(Equal(2 * time.Minute))
+					Expect(notOK.Retryable()).To(BeTrue())
+
+					err = r.DownloadLayer(context.Background(), layer, outputDir)
+					Expect(errors.As(err, &notOK)).To(BeTrue())
+					Expect(notOK.StatusCode()).To(Equal(http.StatusServiceUnavailable))
+					Expect(notOK.RetryAfter()).To(BeNumerically("~", time.Hour, time.Minute))
+				})
+			})
+
+			Context("the registry server stops responding part way through", func() {
+				BeforeEach(func() {
+					layer = v1.Descriptor{
+						Digest:    digest.NewDigestFromEncoded("sha256", layerSHA),
+						MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+					}
+					r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{RequestTimeout: 200 * time.Millisecond})
+
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/blobs/%s", imageName, layer.Digest), ""),
+							func(w http.ResponseWriter, req *http.Request) {
+								w.Write([]byte(layerData[:4]))
+								w.(http.Flusher).Flush()
+								select {
+								case <-req.Context().Done():
+								case <-time.After(5 * time.Second):
+								}
+							},
+						),
+					)
+				})
+
+				It("times out and keeps the partial layer for the next attempt", func() {
+					err := r.DownloadLayer(context.Background(), layer, outputDir)
+					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
+					Expect(errors.Is(err.(*registry.DownloadError).Cause, context.DeadlineExceeded)).To(BeTrue())
+					Expect(filepath.Join(outputDir, layerSHA)).NotTo(BeAnExistingFile())
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA+".partial"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData[:4]))
+				})
+
+				It("removes the partial layer when told that there will be no more attempts", func() {
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).NotTo(Succeed())
+					Expect(filepath.Join(outputDir, layerSHA+".partial")).To(BeAnExistingFile())
+
+					Expect(r.RemovePartial(layer, outputDir)).To(Succeed())
+					Expect(filepath.Join(outputDir, layerSHA+".partial")).NotTo(BeAnExistingFile())
+					Expect(r.RemovePartial(layer, outputDir)).To(Succeed())
+				})
+
+				It("removes the partial layer when the context is cancelled", func() {
+					ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+					defer cancel()
+
+					err := r.DownloadLayer(ctx, layer, outputDir)
+					Expect(err).To(HaveOccurred())
+					Expect(filepath.Join(outputDir, layerSHA)).NotTo(BeAnExistingFile())
+					Expect(filepath.Join(outputDir, layerSHA+".partial")).NotTo(BeAnExistingFile())
+				})
+
+				It("resumes from where it stopped on the next attempt", func() {
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).NotTo(Succeed())
+
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/blobs/%s", imageName, layer.Digest), ""),
+							ghttp.VerifyHeaderKV("Range", "bytes=4-"),
+							ghttp.RespondWith(http.StatusPartialContent, []byte(layerData[4:]), http.Header{
+								"Content-Range": []string{fmt.Sprintf("bytes 4-%d/%d", len(layerData)-1, len(layerData))},
+							}),
+						),
+					)
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+					Expect(filepath.Join(outputDir, layerSHA+".partial")).NotTo(BeAnExistingFile())
+				})
+			})
+
+			Context("the layer is downloaded in chunks", func() {
+				var (
+					layerPath      string
+					ranges         []string
+					rangesMutex    sync.Mutex
+					supportsRanges bool
+				)
+
+				BeforeEach(func() {
+					layer = v1.Descriptor{
+						Digest:    digest.NewDigestFromEncoded("sha256", layerSHA),
+						MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+						Size:      int64(len(layerData)),
+					}
+					layerPath = fmt.Sprintf("/v2/%s/blobs/%s", imageName, layer.Digest)
+					ranges = nil
+					supportsRanges = true
+					r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{ChunkSize: 4, ChunkParallelism: 2})
+
+					registryServer.RouteToHandler("GET", layerPath, func(w http.ResponseWriter, req *http.Request) {
+						rangesMutex.Lock()
+						ranges = append(ranges, req.Header.Get("Range"))
+						rangesMutex.Unlock()
+
+						if !supportsRanges {
+							w.Write([]byte(layerData))
+							return
+						}
+						http.ServeContent(w, req, "", time.Time{}, strings.NewReader(layerData))
+					})
+				})
+
+				It("downloads the chunks and writes them in order", func() {
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+					Expect(ranges).To(ConsistOf("bytes=0-3", "bytes=4-7", "bytes=8-11", "bytes=12-14"))
+				})
+
+				It("only downloads the chunks that are missing from a partial layer", func() {
+					Expect(os.WriteFile(filepath.Join(outputDir, layerSHA+".partial"), []byte(layerData[:6]), 0644)).To(Succeed())
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+					Expect(ranges).To(ConsistOf("bytes=6-9", "bytes=10-13", "bytes=14-14"))
+				})
+
+				It("falls back to a single request when the server does not support ranges", func() {
+					supportsRanges = false
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+					Expect(ranges).To(ContainElement(""))
+				})
+
+				It("does not split layers smaller than the chunk size", func() {
+					r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{ChunkSize: int64(len(layerData))})
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+					Expect(ranges).To(Equal([]string{""}))
+				})
+
+				It("authenticates once for all the chunks when the registry asks for it", func() {
+					authServer.RouteToHandler("GET", "/token", ghttp.RespondWith(http.StatusOK, fmt.Sprintf(`{"token": "%s"}`, token)))
+					registryServer.RouteToHandler("GET", layerPath, func(w http.ResponseWriter, req *http.Request) {
+						if req.Header.Get("Authorization") != "Bearer "+token {
+							w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="%s",scope="repository:%s:pull"`, authServer.URL(), "some-registry-server.io", imageName))
+							w.WriteHeader(http.StatusUnauthorized)
+							return
+						}
+						http.ServeContent(w, req, "", time.Time{}, strings.NewReader(layerData))
+					})
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+					Expect(authServer.ReceivedRequests()).To(HaveLen(1))
+				})
+
+				It("only reports the chunks written to the partial layer when it falls back to a single request", func() {
+					reporter := &progressfakes.Reporter{}
+					r = registry.New(registryServer.URL(), imageName, imageRef, registry.Options{ChunkSize: 4, ChunkParallelism: 2, Progress: reporter})
+
+					registryServer.RouteToHandler("GET", layerPath, func(w http.ResponseWriter, req *http.Request) {
+						switch req.Header.Get("Range") {
+						case "bytes=0-3":
+							/* the second chunk is downloaded before the first one is refused */
+							time.Sleep(100 * time.Millisecond)
+							w.Write([]byte(layerData))
+						case "":
+							w.WriteHeader(http.StatusInternalServerError)
+						default:
+							http.ServeContent(w, req, "", time.Time{}, strings.NewReader(layerData))
+						}
+					})
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).NotTo(Succeed())
+
+					Expect(reporter.UpdateCallCount()).To(BeNumerically(">", 0))
+					_, done := reporter.UpdateArgsForCall(reporter.UpdateCallCount() - 1)
+					Expect(done).To(BeZero())
+				})
+			})
+
+			Context("a partial layer was left by an earlier download", func() {
+				var partialFile string
+
+				BeforeEach(func() {
+					layer = v1.Descriptor{
+						Digest:    digest.NewDigestFromEncoded("sha256", layerSHA),
+						MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+						Size:      int64(len(layerData)),
+					}
+					partialFile = filepath.Join(outputDir, layerSHA+".partial")
+					Expect(os.WriteFile(partialFile, []byte(layerData[:4]), 0644)).To(Succeed())
+				})
+
+				It("requests the rest of the layer", func() {
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyHeaderKV("Range", "bytes=4-"),
+							ghttp.RespondWith(http.StatusPartialContent, []byte(layerData[4:]), http.Header{
+								"Content-Range": []string{fmt.Sprintf("bytes 4-%d/%d", len(layerData)-1, len(layerData))},
+							}),
+						),
+					)
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+				})
+
+				It("starts again when the server ignores the range", func() {
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyHeaderKV("Range", "bytes=4-"),
+							ghttp.RespondWith(http.StatusOK, []byte(layerData)),
+						),
+					)
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+				})
+
+				It("starts again from the beginning of the partial layer when it is longer than the layer", func() {
+					Expect(os.WriteFile(partialFile, []byte(layerData+"-and-more"), 0644)).To(Succeed())
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							func(w http.ResponseWriter, req *http.Request) {
+								Expect(req.Header.Get("Range")).To(BeEmpty())
+							},
+							ghttp.RespondWith(http.StatusOK, []byte(layerData)),
+						),
+					)
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+				})
+
+				It("starts again when the server cannot satisfy the range", func() {
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyHeaderKV("Range", "bytes=4-"),
+							ghttp.RespondWith(http.StatusRequestedRangeNotSatisfiable, nil),
+						),
+						ghttp.CombineHandlers(
+							func(w http.ResponseWriter, req *http.Request) {
+								Expect(req.Header.Get("Range")).To(BeEmpty())
+							},
+							ghttp.RespondWith(http.StatusOK, []byte(layerData)),
+						),
+					)
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+				})
+
+				It("starts again when the server sends a different range", func() {
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyHeaderKV("Range", "bytes=4-"),
+							ghttp.RespondWith(http.StatusPartialContent, []byte(layerData[2:]), http.Header{
+								"Content-Range": []string{fmt.Sprintf("bytes 2-%d/%d", len(layerData)-1, len(layerData))},
+							}),
+						),
+						ghttp.RespondWith(http.StatusOK, []byte(layerData)),
+					)
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+
+					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(layerData))
+				})
+
+				It("removes the partial layer when the sha256 does not match", func() {
+					Expect(os.WriteFile(partialFile, []byte("corr"), 0644)).To(Succeed())
+					registryServer.AppendHandlers(
+						ghttp.RespondWith(http.StatusPartialContent, []byte(layerData[4:]), http.Header{
+							"Content-Range": []string{fmt.Sprintf("bytes 4-%d/%d", len(layerData)-1, len(layerData))},
+						}),
+					)
+
+					err := r.DownloadLayer(context.Background(), layer, outputDir)
+					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
+					Expect(err.(*registry.DownloadError).Cause).To(BeAssignableToTypeOf(&registry.SHAMismatchError{}))
+					Expect(partialFile).NotTo(BeAnExistingFile())
+					Expect(filepath.Join(outputDir, layerSHA)).NotTo(BeAnExistingFile())
+				})
+
+				It("does not download anything when the partial layer is complete", func() {
+					Expect(os.WriteFile(partialFile, []byte(layerData), 0644)).To(Succeed())
+
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
+					Expect(registryServer.ReceivedRequests()).To(BeEmpty())
+					Expect(filepath.Join(outputDir, layerSHA)).To(BeAnExistingFile())
+				})
+			})
+
+			Context("the context is cancelled", func() {
+				BeforeEach(func() {
+					layer = v1.Descriptor{
+						Digest:    digest.NewDigestFromEncoded("sha256", layerSHA),
+						MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+					}
+				})
+
+				It("does not download the layer", func() {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					err := r.DownloadLayer(ctx, layer, outputDir)
+					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
+					Expect(errors.Is(err.(*registry.DownloadError).Cause, context.Canceled)).To(BeTrue())
+					Expect(registryServer.ReceivedRequests()).To(BeEmpty())
+					Expect(filepath.Join(outputDir, layerSHA)).NotTo(BeAnExistingFile())
 				})
 			})
 
@@ -294,7 +1194,7 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					err := r.DownloadLayer(layer, outputDir)
+					err := r.DownloadLayer(context.Background(), layer, outputDir)
 					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
 					Expect(err.(*registry.DownloadError).Cause).To(BeAssignableToTypeOf(&registry.InvalidMediaTypeError{}))
 				})
@@ -338,7 +1238,7 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("downloads a layer for the given image and blob digest", func() {
-					Expect(r.DownloadLayer(layer, outputDir)).To(Succeed())
+					Expect(r.DownloadLayer(context.Background(), layer, outputDir)).To(Succeed())
 
 					data, err := os.ReadFile(filepath.Join(outputDir, layerSHA))
 					Expect(err).NotTo(HaveOccurred())
@@ -371,7 +1271,7 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					err := r.DownloadLayer(layer, outputDir)
+					err := r.DownloadLayer(context.Background(), layer, outputDir)
 					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
 					Expect(err.(*registry.DownloadError).Cause).To(BeAssignableToTypeOf(&registry.HTTPNotOKError{}))
 				})
@@ -408,12 +1308,50 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns the config object for the given descriptor", func() {
-					c, err := r.Config(config)
+					c, _, err := r.Config(context.Background(), config)
 					Expect(err).NotTo(HaveOccurred())
 
 					Expect(c.Architecture).To(Equal("some-arch"))
 					Expect(c.OS).To(Equal("some-os"))
 				})
+
+				It("returns the config as it was sent", func() {
+					_, data, err := r.Config(context.Background(), config)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(configData))
+				})
+			})
+
+			Context("an OCI image config", func() {
+				BeforeEach(func() {
+					config.MediaType = "application/vnd.oci.image.config.v1+json"
+					registryServer.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/v2/%s/blobs/%s", imageName, config.Digest), ""),
+							ghttp.RespondWith(http.StatusOK, []byte(configData)),
+						),
+					)
+				})
+
+				It("returns the config object for the given descriptor", func() {
+					c, _, err := r.Config(context.Background(), config)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(c.OS).To(Equal("some-os"))
+				})
+			})
+
+			Context("the registry sends more than the config size", func() {
+				BeforeEach(func() {
+					registryServer.AppendHandlers(
+						ghttp.RespondWith(http.StatusOK, []byte(configData+"    ")),
+					)
+				})
+
+				It("returns an error", func() {
+					_, _, err := r.Config(context.Background(), config)
+					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
+					Expect(err.(*registry.DownloadError).Cause).To(BeAssignableToTypeOf(&registry.SizeMismatchError{}))
+				})
 			})
 
 			Context("the sha256 does not match", func() {
@@ -427,7 +1365,7 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					_, err := r.Config(config)
+					_, _, err := r.Config(context.Background(), config)
 					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
 					Expect(err.(*registry.DownloadError).Cause).To(BeAssignableToTypeOf(&registry.SHAMismatchError{}))
 				})
@@ -442,7 +1380,7 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					_, err := r.Config(config)
+					_, _, err := r.Config(context.Background(), config)
 					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
 					Expect(err.(*registry.DownloadError).Cause).To(BeAssignableToTypeOf(&registry.DigestAlgorithmError{}))
 				})
@@ -457,7 +1395,7 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					_, err := r.Config(config)
+					_, _, err := r.Config(context.Background(), config)
 					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
 					Expect(err.(*registry.DownloadError).Cause.Error()).To(Equal("invalid checksum digest format"))
 				})
@@ -474,7 +1412,7 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					_, err := r.Config(config)
+					_, _, err := r.Config(context.Background(), config)
 					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
 					Expect(err.(*registry.DownloadError).Cause).To(BeAssignableToTypeOf(&registry.HTTPNotOKError{}))
 				})
@@ -489,7 +1427,7 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					_, err := r.Config(config)
+					_, _, err := r.Config(context.Background(), config)
 					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
 					Expect(err.(*registry.DownloadError).Cause).To(BeAssignableToTypeOf(&registry.InvalidMediaTypeError{}))
 				})
@@ -537,12 +1475,18 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns the config object for the given descriptor", func() {
-					c, err := r.Config(config)
+					c, _, err := r.Config(context.Background(), config)
 					Expect(err).NotTo(HaveOccurred())
 
 					Expect(c.Architecture).To(Equal("some-arch"))
 					Expect(c.OS).To(Equal("some-os"))
 				})
+
+				It("returns the config as it was sent", func() {
+					_, data, err := r.Config(context.Background(), config)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(Equal(configData))
+				})
 			})
 
 			Context("the auth server returns a non-200 response", func() {
@@ -565,7 +1509,7 @@ var _ = Describe("Registry", func() {
 				})
 
 				It("returns an error", func() {
-					_, err := r.Config(config)
+					_, _, err := r.Config(context.Background(), config)
 					Expect(err).To(BeAssignableToTypeOf(&registry.DownloadError{}))
 				})
 			})
@@ -0,0 +1,173 @@
+package registry_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/hydrator/registry"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLS", func() {
+	const manifest = `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","layers":[{"digest":"sha256:abcd"}]}`
+
+	var (
+		server  *httptest.Server
+		certDir string
+		opts    registry.TLSOptions
+	)
+
+	BeforeEach(func() {
+		var err error
+		certDir, err = os.MkdirTemp("", "registry-tls")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(manifest))
+		}))
+		opts = registry.TLSOptions{}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(certDir)).To(Succeed())
+	})
+
+	getManifest := func() error {
+		client, err := registry.NewHTTPClient(opts)
+		Expect(err).NotTo(HaveOccurred())
+
+		r := registry.New(server.URL, "some-image", "some-tag", registry.Options{HTTPClient: client})
+		m, _, _, err := r.Manifest(context.Background())
+		if err == nil {
+			Expect(m.Layers).To(HaveLen(1))
+		}
+		return err
+	}
+
+	writeServerCA := func() string {
+		caFile := filepath.Join(certDir, "ca.pem")
+		Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)).To(Succeed())
+		return caFile
+	}
+
+	Context("the registry certificate is not trusted", func() {
+		BeforeEach(func() {
+			server.StartTLS()
+		})
+
+		It("returns an error", func() {
+			Expect(getManifest()).To(MatchError(ContainSubstring("certificate")))
+		})
+
+		Context("the CA certificate is provided", func() {
+			BeforeEach(func() {
+				opts.CACertFile = writeServerCA()
+			})
+
+			It("downloads from the registry", func() {
+				Expect(getManifest()).To(Succeed())
+			})
+		})
+
+		Context("verification is skipped", func() {
+			BeforeEach(func() {
+				opts.InsecureSkipVerify = true
+			})
+
+			It("downloads from the registry", func() {
+				Expect(getManifest()).To(Succeed())
+			})
+		})
+	})
+
+	Context("the registry requires a client certificate", func() {
+		BeforeEach(func() {
+			certPEM, keyPEM := generateCertificate()
+
+			pool := x509.NewCertPool()
+			Expect(pool.AppendCertsFromPEM(certPEM)).To(BeTrue())
+			server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+			server.StartTLS()
+
+			opts.CACertFile = writeServerCA()
+			opts.ClientCertFile = filepath.Join(certDir, "client.pem")
+			opts.ClientKeyFile = filepath.Join(certDir, "client-key.pem")
+			Expect(os.WriteFile(opts.ClientCertFile, certPEM, 0644)).To(Succeed())
+			Expect(os.WriteFile(opts.ClientKeyFile, keyPEM, 0600)).To(Succeed())
+		})
+
+		It("presents the client certificate", func() {
+			Expect(getManifest()).To(Succeed())
+		})
+
+		Context("no client certificate is provided", func() {
+			BeforeEach(func() {
+				opts.ClientCertFile = ""
+				opts.ClientKeyFile = ""
+			})
+
+			It("returns an error", func() {
+				Expect(getManifest()).NotTo(Succeed())
+			})
+		})
+	})
+
+	Describe("NewHTTPClient", func() {
+		It("returns an error when the CA file does not exist", func() {
+			_, err := registry.NewHTTPClient(registry.TLSOptions{CACertFile: filepath.Join(certDir, "missing.pem")})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns an error when the CA file contains no certificates", func() {
+			caFile := filepath.Join(certDir, "ca.pem")
+			Expect(os.WriteFile(caFile, []byte("not a certificate"), 0644)).To(Succeed())
+
+			_, err := registry.NewHTTPClient(registry.TLSOptions{CACertFile: caFile})
+			Expect(err).To(MatchError(ContainSubstring("no certificates found")))
+		})
+
+		It("returns an error when only the client certificate is provided", func() {
+			_, err := registry.NewHTTPClient(registry.TLSOptions{ClientCertFile: "client.pem"})
+			Expect(err).To(MatchError("both a client certificate and a client key are required"))
+		})
+	})
+})
+
+func generateCertificate() ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "hydrator-client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
@@ -0,0 +1,34 @@
+// docker-credential-fake is a docker credential helper for tests. It only
+// supports get, and knows the credentials for a few fixed servers.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) != 2 || os.Args[1] != "get" {
+		os.Exit(1)
+	}
+
+	server, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	switch strings.TrimSpace(string(server)) {
+	case "helper.example.com":
+		fmt.Println(`{"ServerURL":"helper.example.com","Username":"helper-user","Secret":"helper-secret"}`)
+	case "https://index.docker.io/v1/":
+		fmt.Println(`{"ServerURL":"https://index.docker.io/v1/","Username":"hub-user","Secret":"hub-secret"}`)
+	case "broken.example.com":
+		fmt.Fprintln(os.Stderr, "keychain locked")
+		os.Exit(1)
+	default:
+		fmt.Println("credentials not found in native keychain")
+		os.Exit(1)
+	}
+}